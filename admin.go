@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	channelzsvc "google.golang.org/grpc/channelz/service"
+)
+
+const (
+	defaultAdminSocket = "/var/run/srl-grpc-tunnel.sock"
+	adminStatePath     = "/state"
+)
+
+// runtimeState is the agent runtime state returned by the admin API.
+type runtimeState struct {
+	AdminState string                `json:"admin-state,omitempty"`
+	OperState  string                `json:"oper-state,omitempty"`
+	Tunnels    []*tunnelRuntimeState `json:"tunnels,omitempty"`
+	Sessions   []*sessionState       `json:"sessions,omitempty"`
+}
+
+type tunnelRuntimeState struct {
+	Name                string                     `json:"name,omitempty"`
+	AdminState          string                     `json:"admin-state,omitempty"`
+	OperState           string                     `json:"oper-state,omitempty"`
+	OperStateDownReason string                     `json:"oper-state-down-reason,omitempty"`
+	Destinations        []*destinationRuntimeState `json:"destinations,omitempty"`
+}
+
+type destinationRuntimeState struct {
+	Name                string                `json:"name,omitempty"`
+	Address             string                `json:"address,omitempty"`
+	AdminState          string                `json:"admin-state,omitempty"`
+	OperState           string                `json:"oper-state,omitempty"`
+	OperStateDownReason string                `json:"oper-state-down-reason,omitempty"`
+	Connection          string                `json:"connection,omitempty"`
+	Targets             []*targetRuntimeState `json:"targets,omitempty"`
+}
+
+type targetRuntimeState struct {
+	Name         string `json:"name,omitempty"`
+	ID           string `json:"id,omitempty"`
+	Type         string `json:"type,omitempty"`
+	LocalAddress string `json:"local-address,omitempty"`
+	Exec         string `json:"exec,omitempty"`
+	GrpcProxy    bool   `json:"grpc-proxy,omitempty"`
+}
+
+type sessionState struct {
+	ID           uint64    `json:"id,omitempty"`
+	Tunnel       string    `json:"tunnel,omitempty"`
+	Destination  string    `json:"destination,omitempty"`
+	Target       string    `json:"target,omitempty"`
+	TargetID     string    `json:"target-id,omitempty"`
+	TargetType   string    `json:"target-type,omitempty"`
+	LocalAddress string    `json:"local-address,omitempty"`
+	Start        time.Time `json:"start,omitempty"`
+	Duration     string    `json:"duration,omitempty"`
+	Idle         string    `json:"idle,omitempty"`
+}
+
+// runtimeState builds the agent runtime state from its config and tunnel clients.
+// The config and the tunnel clients are copied under separate short locks,
+// so that the admin API does not hold a lock while waiting for the other.
+func (a *app) runtimeState() *runtimeState {
+	rs := a.configRuntimeState()
+
+	a.m.RLock()
+	defer a.m.RUnlock()
+	for _, trs := range rs.Tunnels {
+		for _, drs := range trs.Destinations {
+			tdc, ok := a.tunnelClients[trs.Name][drs.Name]
+			if !ok || tdc.conn == nil {
+				continue
+			}
+			drs.Connection = tdc.conn.GetState().String()
+			for _, ttd := range tdc.allTargets() {
+				tgs := &targetRuntimeState{
+					Name:         ttd.name,
+					ID:           ttd.ID,
+					Type:         ttd.Type,
+					LocalAddress: ttd.dialAddress,
+					GrpcProxy:    ttd.proxy != nil,
+				}
+				if ttd.exec != nil {
+					tgs.Exec = strings.Join(append([]string{ttd.exec.command}, ttd.exec.args...), " ")
+				}
+				drs.Targets = append(drs.Targets, tgs)
+			}
+			sort.Slice(drs.Targets, func(i, j int) bool {
+				if drs.Targets[i].Name == drs.Targets[j].Name {
+					return drs.Targets[i].ID+drs.Targets[i].Type < drs.Targets[j].ID+drs.Targets[j].Type
+				}
+				return drs.Targets[i].Name < drs.Targets[j].Name
+			})
+		}
+	}
+	rs.Sessions = make([]*sessionState, 0, len(a.sessions))
+	now := time.Now()
+	for _, s := range a.sessions {
+		rs.Sessions = append(rs.Sessions, &sessionState{
+			ID:           s.id,
+			Tunnel:       s.tunnel,
+			Destination:  s.destination,
+			Target:       s.target,
+			TargetID:     s.tt.ID,
+			TargetType:   s.tt.Type,
+			LocalAddress: s.localAddress,
+			Start:        s.start,
+			Duration:     now.Sub(s.start).Round(time.Second).String(),
+			Idle:         now.Sub(time.Unix(0, s.lastActivity.Load())).Round(time.Second).String(),
+		})
+	}
+	sort.Slice(rs.Sessions, func(i, j int) bool {
+		return rs.Sessions[i].ID < rs.Sessions[j].ID
+	})
+	return rs
+}
+
+// configRuntimeState copies the app, tunnels and destinations states from the config.
+func (a *app) configRuntimeState() *runtimeState {
+	a.config.m.Lock()
+	defer a.config.m.Unlock()
+
+	rs := &runtimeState{
+		AdminState: a.config.app.AdminState,
+		OperState:  a.config.app.OperState,
+		Tunnels:    make([]*tunnelRuntimeState, 0, len(a.config.app.Tunnel)),
+	}
+	for tn, tun := range a.config.app.Tunnel {
+		trs := &tunnelRuntimeState{
+			Name:                tn,
+			AdminState:          tun.Tunnel.AdminState,
+			OperState:           tun.Tunnel.OperState,
+			OperStateDownReason: tun.Tunnel.OperStateDownReason.Value,
+			Destinations:        make([]*destinationRuntimeState, 0, len(tun.Tunnel.Destination)),
+		}
+		for dn, destState := range tun.Tunnel.Destination {
+			drs := &destinationRuntimeState{
+				Name:                dn,
+				AdminState:          destState.AdminState,
+				OperState:           destState.OperState,
+				OperStateDownReason: destState.OperStateDownReason.Value,
+				Connection:          "NOT_CONNECTED",
+				Targets:             make([]*targetRuntimeState, 0),
+			}
+			if dest, ok := a.config.app.Destination[dn]; ok {
+				drs.Address = net.JoinHostPort(dest.Destination.Address.Value, dest.Destination.Port.Value)
+			}
+			trs.Destinations = append(trs.Destinations, drs)
+		}
+		sort.Slice(trs.Destinations, func(i, j int) bool {
+			return trs.Destinations[i].Name < trs.Destinations[j].Name
+		})
+		rs.Tunnels = append(rs.Tunnels, trs)
+	}
+	sort.Slice(rs.Tunnels, func(i, j int) bool {
+		return rs.Tunnels[i].Name < rs.Tunnels[j].Name
+	})
+	return rs
+}
+
+// serveAdmin serves the admin API on the unix socket at path:
+// the runtime state as JSON, pprof and the gRPC channelz service.
+// It returns when ctx is done.
+func (a *app) serveAdmin(ctx context.Context, path string) {
+	os.Remove(path)
+	l, err := listenAdmin(path)
+	if err != nil {
+		log.Errorf("failed to listen on admin socket %s: %v", path, err)
+		return
+	}
+	defer os.Remove(path)
+
+	gs := grpc.NewServer()
+	channelzsvc.RegisterChannelzServiceToServer(gs)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(adminStatePath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(a.runtimeState()); err != nil {
+			log.Errorf("failed to encode runtime state: %v", err)
+		}
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	srv := &http.Server{
+		// gRPC requests (channelz) are served over cleartext HTTP/2
+		Handler: h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+				gs.ServeHTTP(w, r)
+				return
+			}
+			mux.ServeHTTP(w, r)
+		}), &http2.Server{}),
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+		gs.Stop()
+	}()
+	log.Infof("admin API listening on %s", path)
+	err = srv.Serve(l)
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("admin API stopped: %v", err)
+	}
+}
+
+// listenAdmin listens on the unix socket at path, only accessible by its owner.
+// The permissions are set before the socket is served. The umask is not changed:
+// it is process wide and would apply to the files other goroutines create meanwhile.
+func listenAdmin(path string) (net.Listener, error) {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set the admin socket permissions: %v", err)
+	}
+	return l, nil
+}
+
+// runStatus implements the status subcommand:
+// status [-admin-socket <path>]
+// it queries the runtime state from the admin API and prints it to w.
+// The socket path defaults to socket, the value of the global -admin-socket flag.
+func runStatus(args []string, socket string, w io.Writer) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	path := fs.String("admin-socket", socket, "admin API unix socket path")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: status [-admin-socket <path>]")
+	}
+	return queryStatus(*path, w)
+}
+
+// queryStatus queries the runtime state from the admin API at socket path and prints it to w.
+func queryStatus(path string, w io.Writer) error {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	rsp, err := client.Get("http://admin" + adminStatePath)
+	if err != nil {
+		return fmt.Errorf("failed to query the agent admin API at %s: %v", path, err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to query the agent admin API at %s: %s", path, rsp.Status)
+	}
+	_, err = io.Copy(w, rsp.Body)
+	return err
+}
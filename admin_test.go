@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/openconfig/grpctunnel/tunnel"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// newTestConn returns a client connection that is not connected until used.
+func newTestConn(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial("passthrough:///127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to create client connection: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// newTestRuntimeApp returns an app with tunnel t1 using destinations d1, connected, and d2, not connected.
+func newTestRuntimeApp(t *testing.T) (*app, *tunnelDestinationClient) {
+	t.Helper()
+	a := newTestApp()
+	a.config.app.AdminState = adminEnable
+	a.config.app.OperState = operUp
+	dest := new(destination)
+	dest.Destination.Address.Value = "10.0.0.1"
+	dest.Destination.Port.Value = "57401"
+	a.config.app.Destination["d1"] = dest
+	tun := new(tunnelCfg)
+	tun.Tunnel.AdminState = adminEnable
+	tun.Tunnel.OperState = operUp
+	tun.Tunnel.Destination = map[string]*destinationState{
+		"d1": {AdminState: adminEnable, OperState: operUp},
+		"d2": {AdminState: adminEnable, OperState: operDown, OperStateDownReason: stringValue{Value: "connection failed"}},
+	}
+	a.config.app.Tunnel["t1"] = tun
+	tdc := newTunnelDestinationClient(newTestConn(t), nil, nil)
+	a.tunnelClients["t1"] = map[string]*tunnelDestinationClient{
+		"d1": tdc,
+		"d2": newTunnelDestinationClient(nil, nil, nil),
+	}
+	return a, tdc
+}
+
+func TestRuntimeState(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets map[string][]*tunnelTargetDetails
+		// targets with a running session
+		sessions    []string
+		wantTargets []targetRuntimeState
+	}{
+		{
+			name: "no target",
+		},
+		{
+			name: "targets sorted by name, ID and type",
+			targets: map[string][]*tunnelTargetDetails{
+				"tg2": {{ID: "srl1", Type: "SSH", dialAddress: "localhost:22"}},
+				"tg1": {
+					{ID: "srl1", Type: "GNMI_GNOI", dialAddress: "localhost:57400", proxy: new(grpcProxyConfig)},
+					{ID: "srl1", Type: "GNMI", dialAddress: "localhost:57400"},
+				},
+			},
+			wantTargets: []targetRuntimeState{
+				{Name: "tg1", ID: "srl1", Type: "GNMI", LocalAddress: "localhost:57400"},
+				{Name: "tg1", ID: "srl1", Type: "GNMI_GNOI", LocalAddress: "localhost:57400", GrpcProxy: true},
+				{Name: "tg2", ID: "srl1", Type: "SSH", LocalAddress: "localhost:22"},
+			},
+		},
+		{
+			name: "exec target and session",
+			targets: map[string][]*tunnelTargetDetails{
+				"shell": {{ID: "srl1", Type: "SHELL", exec: &execConfig{command: "/bin/bash", args: []string{"-l"}}}},
+			},
+			sessions: []string{"shell"},
+			wantTargets: []targetRuntimeState{
+				{Name: "shell", ID: "srl1", Type: "SHELL", Exec: "/bin/bash -l"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, tdc := newTestRuntimeApp(t)
+			for tg, ttds := range tt.targets {
+				for _, ttd := range ttds {
+					ttd.name = tg
+				}
+				tdc.setTargets(tg, ttds)
+			}
+			for _, tg := range tt.sessions {
+				ttd := tt.targets[tg][0]
+				s := newSession(log.NewEntry(log.StandardLogger()), "t1", "d1", ttd, tunnel.Target{ID: ttd.ID, Type: ttd.Type})
+				a.addSession(s)
+				defer a.endSession(s)
+			}
+			rs := a.runtimeState()
+			if rs.AdminState != adminEnable || rs.OperState != operUp {
+				t.Errorf("app state = %s/%s, want %s/%s", rs.AdminState, rs.OperState, adminEnable, operUp)
+			}
+			if len(rs.Tunnels) != 1 || len(rs.Tunnels[0].Destinations) != 2 {
+				t.Fatalf("runtimeState() tunnels = %+v, want t1 with 2 destinations", rs.Tunnels)
+			}
+			d1, d2 := rs.Tunnels[0].Destinations[0], rs.Tunnels[0].Destinations[1]
+			if d1.Name != "d1" || d1.Address != "10.0.0.1:57401" || d1.Connection == "NOT_CONNECTED" {
+				t.Errorf("destination d1 = %+v, want address 10.0.0.1:57401 with a connection state", d1)
+			}
+			if d2.Name != "d2" || d2.Connection != "NOT_CONNECTED" || d2.OperStateDownReason != "connection failed" || len(d2.Targets) != 0 {
+				t.Errorf("destination d2 = %+v, want not connected without targets", d2)
+			}
+			if len(d1.Targets) != len(tt.wantTargets) {
+				t.Fatalf("destination d1 has %d targets, want %d", len(d1.Targets), len(tt.wantTargets))
+			}
+			for i, want := range tt.wantTargets {
+				if *d1.Targets[i] != want {
+					t.Errorf("target %d = %+v, want %+v", i, *d1.Targets[i], want)
+				}
+			}
+			if len(rs.Sessions) != len(tt.sessions) {
+				t.Fatalf("runtimeState() has %d sessions, want %d", len(rs.Sessions), len(tt.sessions))
+			}
+			for i, tg := range tt.sessions {
+				if s := rs.Sessions[i]; s.Tunnel != "t1" || s.Destination != "d1" || s.Target != tg || s.LocalAddress != "exec:/bin/bash" {
+					t.Errorf("session %d = %+v, want target %s", i, s, tg)
+				}
+			}
+		})
+	}
+}
+
+// TestRuntimeStateConcurrentTargets checks, with the race detector,
+// that the runtime state can be read while targets are registered and deleted.
+func TestRuntimeStateConcurrentTargets(t *testing.T) {
+	a, tdc := newTestRuntimeApp(t)
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			tg := fmt.Sprintf("tg%d", i%5)
+			tdc.setTargets(tg, []*tunnelTargetDetails{{name: tg, ID: "srl1", Type: fmt.Sprintf("TYPE%d", i)}})
+			if i%3 == 0 {
+				tdc.removeTargets(tg)
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		if rs := a.runtimeState(); len(rs.Tunnels) != 1 {
+			t.Fatalf("runtimeState() tunnels = %+v, want t1", rs.Tunnels)
+		}
+		tdc.lookupTarget(tunnel.Target{ID: "srl1", Type: "TYPE1"})
+	}
+	wg.Wait()
+}
+
+func TestListenAdmin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	l, err := listenAdmin(path)
+	if err != nil {
+		t.Fatalf("listenAdmin() error = %v", err)
+	}
+	defer l.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %s, want a socket with permissions 0600", fi.Mode())
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect to the admin socket: %v", err)
+	}
+	conn.Close()
+}
@@ -2,12 +2,11 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"os"
 	"sync"
 
 	agent "github.com/karimra/srl-ndk-demo"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/encoding/prototext"
 )
 
 type Option func(*app)
@@ -20,6 +19,23 @@ type app struct {
 	m *sync.RWMutex
 	// [tunnelName] / [destinationName]
 	tunnelClients map[string]map[string]*tunnelDestinationClient
+	// [tunnelName] / [targetName]
+	targetStats map[string]map[string]*targetStats
+	// [tunnelName]
+	tunnelStats map[string]*tunnelStats
+	// active sessions, by ID
+	sessions map[uint64]*session
+	// published telemetry
+	telemetry *telemetryCache
+	publisher *telemetryPublisher
+	// application and per tunnel log levels
+	logLevels *logLevels
+	// session audit log
+	audit *auditLog
+	// oper-state transitions history
+	stateHistories *stateHistories
+	// running webhooks
+	webhooks *webhooks
 }
 
 func WithAgent(agt *agent.Agent) func(a *app) {
@@ -35,6 +51,16 @@ func newApp(ctx context.Context, opts ...Option) *app {
 		//
 		m:             new(sync.RWMutex),
 		tunnelClients: make(map[string]map[string]*tunnelDestinationClient),
+		targetStats:   make(map[string]map[string]*targetStats),
+		tunnelStats:   make(map[string]*tunnelStats),
+		sessions:      make(map[uint64]*session),
+		telemetry:     newTelemetryCache(),
+		publisher:     newTelemetryPublisher(),
+		logLevels:     newLogLevels(log.GetLevel()),
+		audit:         newAuditLog(),
+		//
+		stateHistories: newStateHistories(),
+		webhooks:       newWebhooks(),
 	}
 
 	for _, opt := range opts {
@@ -45,21 +71,18 @@ func newApp(ctx context.Context, opts ...Option) *app {
 
 // starts config notification and network instance notification streams.
 // listens to updates from both and calls relevant handlers.
-func (a *app) start(ctx context.Context) {
+// returns when ctx is done or a signal is received on sigs.
+func (a *app) start(ctx context.Context, sigs <-chan os.Signal) {
+	a.purgeTelemetry()
 	cfgStream := a.agent.StartConfigNotificationStream(ctx)
 	nwInstStream := a.agent.StartNwInstNotificationStream(ctx)
+	go a.statsLoop(ctx)
+	go a.telemetryLoop(ctx)
+	go a.publishLoop(ctx)
 	for {
 		select {
 		case nwInstEvent := <-nwInstStream:
 			log.Debugf("NwInst notification: %+v", nwInstEvent)
-
-			b, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(nwInstEvent)
-			if err != nil {
-				log.Errorf("NwInst notification Marshal failed: %+v", err)
-				continue
-			}
-			fmt.Printf("%s\n", string(b))
-
 			for _, ev := range nwInstEvent.GetNotification() {
 				if nwInst := ev.GetNwInst(); nwInst != nil {
 					a.handleNwInstCfg(ctx, nwInst)
@@ -68,15 +91,7 @@ func (a *app) start(ctx context.Context) {
 				log.Warnf("got empty nwInst, event: %+v", ev)
 			}
 		case event := <-cfgStream:
-			log.Infof("Config notification: %+v", event)
-
-			b, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(event)
-			if err != nil {
-				log.Infof("Config notification Marshal failed: %+v", err)
-				continue
-			}
-			fmt.Printf("%s\n", string(b))
-
+			log.Debugf("Config notification: %+v", event)
 			for _, ev := range event.GetNotification() {
 				if cfg := ev.GetConfig(); cfg != nil {
 					a.handleConfigEvent(ctx, cfg)
@@ -84,6 +99,9 @@ func (a *app) start(ctx context.Context) {
 				}
 				log.Infof("got empty config, event: %+v", ev)
 			}
+		case sig := <-sigs:
+			log.Infof("received signal %s, shutting down", sig)
+			return
 		case <-ctx.Done():
 			return
 		}
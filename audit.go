@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	defaultAuditMaxSize    = 10
+	defaultAuditMaxBackups = 5
+	defaultSyslogPort      = "514"
+	// log audit
+	defaultSyslogFacility = 13
+	syslogSeverityInfo    = 6
+	syslogAppName         = "srl-grpc-tunnel"
+	syslogMsgID           = "session"
+	syslogTimeout         = 2 * time.Second
+	syslogQueueSize       = 256
+
+	auditSyslogStatisticsPath = ".system.grpc_tunnel.audit_log.syslog.statistics"
+)
+
+type auditLogCfg struct {
+	File       stringValue `json:"file,omitempty"`
+	MaxSize    uint32Value `json:"max_size,omitempty"`
+	MaxBackups uint32Value `json:"max_backups,omitempty"`
+	Syslog     struct {
+		Address         stringValue  `json:"address,omitempty"`
+		Port            stringValue  `json:"port,omitempty"`
+		Transport       string       `json:"transport,omitempty"`
+		NetworkInstance stringValue  `json:"network_instance,omitempty"`
+		Facility        *uint32Value `json:"facility,omitempty"`
+	} `json:"syslog,omitempty"`
+}
+
+// auditRecord is the audit log record of a session.
+type auditRecord struct {
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Session      uint64    `json:"session"`
+	Tunnel       string    `json:"tunnel"`
+	Destination  string    `json:"destination"`
+	Target       string    `json:"target"`
+	TargetID     string    `json:"target-id"`
+	TargetType   string    `json:"target-type"`
+	LocalAddress string    `json:"local-address"`
+	RxBytes      uint64    `json:"rx-bytes"`
+	TxBytes      uint64    `json:"tx-bytes"`
+	Duration     float64   `json:"duration"`
+	CloseReason  string    `json:"close-reason"`
+}
+
+func newAuditRecord(s *session) *auditRecord {
+	return &auditRecord{
+		Start:        s.start,
+		End:          s.end,
+		Session:      s.id,
+		Tunnel:       s.tunnel,
+		Destination:  s.destination,
+		Target:       s.target,
+		TargetID:     s.tt.ID,
+		TargetType:   s.tt.Type,
+		LocalAddress: s.localAddress,
+		RxBytes:      s.rxBytes.Load(),
+		TxBytes:      s.txBytes.Load(),
+		Duration:     s.end.Sub(s.start).Seconds(),
+		CloseReason:  s.getCloseReason(),
+	}
+}
+
+// auditLog writes the session records to a JSONL file and/or a syslog server.
+type auditLog struct {
+	m   *sync.Mutex
+	cfg auditLogCfg
+	// nil if the file audit log is disabled
+	file *rotatingFile
+	// nil if the syslog audit log is disabled
+	syslog *syslogWriter
+}
+
+func newAuditLog() *auditLog {
+	return &auditLog{m: new(sync.Mutex)}
+}
+
+// setAuditLog applies the audit log configuration,
+// the file and the syslog connection are reopened only if their config changed:
+// a file that fails to open is not retried until its config changes.
+func (a *app) setAuditLog(cfg auditLogCfg) {
+	al := a.audit
+	al.m.Lock()
+	defer al.m.Unlock()
+	old := al.cfg
+	al.cfg = cfg
+	if old.File != cfg.File || old.MaxSize != cfg.MaxSize || old.MaxBackups != cfg.MaxBackups {
+		if al.file != nil {
+			al.file.Close()
+			al.file = nil
+		}
+		if cfg.File.Value != "" {
+			maxSize := int64(defaultAuditMaxSize)
+			if cfg.MaxSize.Value > 0 {
+				maxSize = int64(cfg.MaxSize.Value)
+			}
+			maxBackups := defaultAuditMaxBackups
+			if cfg.MaxBackups.Value > 0 {
+				maxBackups = int(cfg.MaxBackups.Value)
+			}
+			rf, err := newRotatingFile(cfg.File.Value, maxSize*1024*1024, maxBackups, 0600)
+			if err != nil {
+				log.Errorf("failed to open audit log file %s: %v", cfg.File.Value, err)
+			} else {
+				log.Infof("writing the session audit log to %s", cfg.File.Value)
+				al.file = rf
+			}
+		}
+	}
+	var sw *syslogWriter
+	if cfg.Syslog.Address.Value != "" {
+		sw = newSyslogWriter(cfg, a.config.sysInfo.Name)
+	}
+	if al.syslog != nil && sw != nil && al.syslog.sameServer(sw) {
+		return
+	}
+	if al.syslog != nil {
+		al.syslog.stop()
+		if sw == nil {
+			a.deleteTelemetryPath(auditSyslogStatisticsPath)
+		}
+	}
+	al.syslog = sw
+	if sw != nil {
+		ctx, cancel := context.WithCancel(a.ctx)
+		sw.cancel = cancel
+		go sw.run(ctx, func() { a.updateAuditSyslogStatisticsTelemetry(sw) })
+		log.Infof("sending the session audit log to syslog server %s", sw.addr)
+	}
+}
+
+// auditSession writes the audit record of a closed session.
+// The syslog records are queued, they are dropped if the queue is full.
+func (a *app) auditSession(s *session) {
+	al := a.audit
+	al.m.Lock()
+	defer al.m.Unlock()
+	if al.file == nil && al.syslog == nil {
+		return
+	}
+	b, err := json.Marshal(newAuditRecord(s))
+	if err != nil {
+		s.log.Errorf("failed to marshal audit record: %v", err)
+		return
+	}
+	if al.file != nil {
+		_, err = al.file.Write(append(b, '\n'))
+		if err != nil {
+			s.log.Errorf("failed to write audit record: %v", err)
+		}
+	}
+	if al.syslog != nil {
+		select {
+		case al.syslog.records <- syslogRecord{ts: s.end, msg: b}:
+		default:
+			al.syslog.dropped.Add(1)
+			s.log.Warnf("syslog server %s queue full, audit record dropped", al.syslog.addr)
+			a.updateAuditSyslogStatisticsTelemetry(al.syslog)
+		}
+	}
+}
+
+func (a *app) updateAuditSyslogStatisticsTelemetry(sw *syslogWriter) {
+	jsData, err := json.Marshal(&auditSyslogStatistics{
+		SentRecords:    uint64Value{Value: sw.sent.Load()},
+		FailedRecords:  uint64Value{Value: sw.failed.Load()},
+		DroppedRecords: uint64Value{Value: sw.dropped.Load()},
+	})
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	a.updateTelemetryPathConfig(auditSyslogStatisticsPath, string(jsData))
+}
+
+type auditSyslogStatistics struct {
+	SentRecords    uint64Value `json:"sent_records,omitempty"`
+	FailedRecords  uint64Value `json:"failed_records,omitempty"`
+	DroppedRecords uint64Value `json:"dropped_records,omitempty"`
+}
+
+// syslogRecord is an audit record queued to a syslog server.
+type syslogRecord struct {
+	ts  time.Time
+	msg []byte
+}
+
+// syslogWriter sends RFC5424 messages to a syslog server,
+// from the configured network-instance.
+// The messages are queued and sent by run, so that a slow server does not block the sessions.
+type syslogWriter struct {
+	network  string
+	addr     string
+	netIns   string
+	priority int
+	hostname string
+	records  chan syslogRecord
+	cancel   context.CancelFunc
+	// only used by run
+	conn net.Conn
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+func newSyslogWriter(cfg auditLogCfg, hostname string) *syslogWriter {
+	port := cfg.Syslog.Port.Value
+	if port == "" {
+		port = defaultSyslogPort
+	}
+	netIns := cfg.Syslog.NetworkInstance.Value
+	if netIns == "" {
+		netIns = "mgmt"
+	}
+	facility := defaultSyslogFacility
+	if cfg.Syslog.Facility != nil {
+		facility = int(cfg.Syslog.Facility.Value)
+	}
+	network := "udp"
+	if strings.HasSuffix(cfg.Syslog.Transport, "tcp") {
+		network = "tcp"
+	}
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	return &syslogWriter{
+		network:  network,
+		addr:     net.JoinHostPort(cfg.Syslog.Address.Value, port),
+		netIns:   netIns,
+		priority: facility*8 + syslogSeverityInfo,
+		hostname: hostname,
+		records:  make(chan syslogRecord, syslogQueueSize),
+	}
+}
+
+// run sends the queued records until ctx is done, the queued records are then dropped.
+func (sw *syslogWriter) run(ctx context.Context, updateStats func()) {
+	defer sw.close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-sw.records:
+			err := sw.write(ctx, r.ts, r.msg)
+			if err != nil {
+				log.Errorf("failed to send audit record to syslog server %s: %v", sw.addr, err)
+				sw.failed.Add(1)
+			} else {
+				sw.sent.Add(1)
+			}
+			updateStats()
+		}
+	}
+}
+
+// stop stops sending the records.
+func (sw *syslogWriter) stop() {
+	if sw.cancel != nil {
+		sw.cancel()
+	}
+}
+
+// write sends msg to the syslog server, connecting to it if needed.
+// TCP messages are framed with their length (RFC6587 octet counting).
+func (sw *syslogWriter) write(ctx context.Context, ts time.Time, msg []byte) error {
+	if sw.conn == nil {
+		conn, err := dialNetworkInstance(ctx, sw.netIns, sw.network, sw.addr, syslogTimeout)
+		if err != nil {
+			return err
+		}
+		sw.conn = conn
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		sw.priority, ts.UTC().Format(time.RFC3339Nano), sw.hostname, syslogAppName, os.Getpid(), syslogMsgID, msg)
+	if sw.network == "tcp" {
+		line = strconv.Itoa(len(line)) + " " + line
+	}
+	sw.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err := sw.conn.Write([]byte(line))
+	if err != nil {
+		// reconnect on the next message
+		sw.close()
+	}
+	return err
+}
+
+// sameServer returns true if sw and other send the same messages to the same server.
+func (sw *syslogWriter) sameServer(other *syslogWriter) bool {
+	return sw.network == other.network && sw.addr == other.addr && sw.netIns == other.netIns &&
+		sw.priority == other.priority && sw.hostname == other.hostname
+}
+
+func (sw *syslogWriter) close() {
+	if sw.conn != nil {
+		sw.conn.Close()
+		sw.conn = nil
+	}
+}
+
+// dialNetworkInstance connects to addr from the namespace of network-instance netIns,
+// giving up after timeout or once ctx is done.
+// The socket is created by a dedicated OS thread, which is discarded once done.
+func dialNetworkInstance(ctx context.Context, netIns, network, addr string, timeout time.Duration) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		// the thread is not unlocked, it exits with the goroutine
+		runtime.LockOSThread()
+		netInsName := fmt.Sprintf("srbase-%s", netIns)
+		n, err := netns.GetFromName(netInsName)
+		if err != nil {
+			ch <- result{err: fmt.Errorf("failed getting NS %q: %v", netInsName, err)}
+			return
+		}
+		defer n.Close()
+		if err = netns.Set(n); err != nil {
+			ch <- result{err: fmt.Errorf("failed setting NS to %q: %v", netInsName, err)}
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		conn, err := new(net.Dialer).DialContext(ctx, network, addr)
+		ch <- result{conn: conn, err: err}
+	}()
+	r := <-ch
+	return r.conn, r.err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestSetAuditLogFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "audit.log")
+	// a directory cannot be opened as the audit log file
+	invalid := dir
+	type update struct {
+		cfg string
+		// the file is expected to be open, and reopened
+		wantOpen     bool
+		wantReopened bool
+		// number of open errors logged by the update
+		wantErrors int
+	}
+	tests := []struct {
+		name    string
+		updates []update
+	}{
+		{
+			name: "unchanged config",
+			updates: []update{
+				{cfg: `{"file":{"value":"` + file + `"}}`, wantOpen: true, wantReopened: true},
+				{cfg: `{"file":{"value":"` + file + `"}}`, wantOpen: true},
+				{cfg: `{"file":{"value":"` + file + `"},"syslog":{}}`, wantOpen: true},
+			},
+		},
+		{
+			name: "changed config",
+			updates: []update{
+				{cfg: `{"file":{"value":"` + file + `"}}`, wantOpen: true, wantReopened: true},
+				{cfg: `{"file":{"value":"` + file + `"},"max_size":{"value":1}}`, wantOpen: true, wantReopened: true},
+				{cfg: `{"file":{"value":"` + file + `"},"max_size":{"value":1},"max_backups":{"value":2}}`, wantOpen: true, wantReopened: true},
+				{cfg: `{}`},
+			},
+		},
+		{
+			name: "open error logged once",
+			updates: []update{
+				{cfg: `{"file":{"value":"` + invalid + `"}}`, wantErrors: 1},
+				{cfg: `{"file":{"value":"` + invalid + `"}}`},
+				{cfg: `{"file":{"value":"` + file + `"}}`, wantOpen: true, wantReopened: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := logtest.NewLocal(log.StandardLogger())
+			defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			a := newTestApp()
+			defer a.setAuditLog(auditLogCfg{})
+			for i, u := range tt.updates {
+				cfg := auditLogCfg{}
+				if err := json.Unmarshal([]byte(u.cfg), &cfg); err != nil {
+					t.Fatalf("failed to decode audit log config %s: %v", u.cfg, err)
+				}
+				hook.Reset()
+				prev := a.audit.file
+				a.setAuditLog(cfg)
+				if got := a.audit.file != nil; got != u.wantOpen {
+					t.Fatalf("update %d: file open = %v, want %v", i+1, got, u.wantOpen)
+				}
+				if got := a.audit.file != prev; u.wantOpen && got != u.wantReopened {
+					t.Errorf("update %d: file reopened = %v, want %v", i+1, got, u.wantReopened)
+				}
+				errs := 0
+				for _, e := range hook.AllEntries() {
+					if e.Level == log.ErrorLevel && strings.Contains(e.Message, "failed to open audit log file") {
+						errs++
+					}
+				}
+				if errs != u.wantErrors {
+					t.Errorf("update %d: %d open errors logged, want %d", i+1, errs, u.wantErrors)
+				}
+			}
+		})
+	}
+}
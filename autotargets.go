@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// auto targets local names are prefixed to avoid conflicts with configured targets
+const autoTargetPrefix = "auto:"
+
+// watchServices subscribes to the management servers configuration
+// and refreshes the local services and the tunnels auto targets on change.
+func (a *app) watchServices(ctx context.Context) {
+	for {
+		err := a.subscribeServices(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog := log.WithField("server", gnmiServerUnixSocket)
+		slog.Errorf("services subscription failed: %v", err)
+		slog.Infof("retrying in %s", retryInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (a *app) subscribeServices(ctx context.Context) error {
+	ctx = withGnmiCredentials(ctx, a.config.username, a.config.password)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, gnmiServerUnixSocket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stream, err := gnmi.NewGNMIClient(conn).Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	subs := make([]*gnmi.Subscription, 0, len(servicesPaths))
+	for _, p := range servicesPaths {
+		subs = append(subs, &gnmi.Subscription{Path: p, Mode: gnmi.SubscriptionMode_ON_CHANGE})
+	}
+	err = stream.Send(&gnmi.SubscribeRequest{
+		Request: &gnmi.SubscribeRequest_Subscribe{
+			Subscribe: &gnmi.SubscriptionList{
+				Mode:         gnmi.SubscriptionList_STREAM,
+				Encoding:     gnmi.Encoding_ASCII,
+				Subscription: subs,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	synced := false
+	for {
+		rsp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch rsp.GetResponse().(type) {
+		case *gnmi.SubscribeResponse_SyncResponse:
+			synced = true
+			a.refreshServices(ctx)
+		case *gnmi.SubscribeResponse_Update:
+			// the initial updates are covered by the sync response refresh
+			if synced {
+				a.refreshServices(ctx)
+			}
+		}
+	}
+}
+
+// refreshServices re discovers the local services and, if they changed,
+// updates the auto targets of all tunnels.
+// It is called by a single goroutine at a time: once at startup, then by watchServices.
+func (a *app) refreshServices(ctx context.Context) {
+	services, err := a.discoverServices(ctx)
+	if err != nil {
+		log.WithField("server", gnmiServerUnixSocket).Errorf("failed to discover local services: %v", err)
+		return
+	}
+	if !a.setServices(services) {
+		return
+	}
+
+	a.config.m.Lock()
+	defer a.config.m.Unlock()
+	for tn, tun := range a.config.app.Tunnel {
+		a.syncAutoTargets(a.ctx, tn, tun)
+	}
+}
+
+// configuredTypes returns the types registered by the enabled configured targets of a tunnel.
+func (a *app) configuredTypes(tun *tunnelCfg) map[string]struct{} {
+	types := make(map[string]struct{})
+	for _, tg := range tun.Tunnel.Target {
+		if tg.Target.AdminState == adminDisable {
+			continue
+		}
+		ttds, err := a.targetTypes(tg)
+		if err != nil {
+			continue
+		}
+		for _, ttd := range ttds {
+			types[ttd.Type] = struct{}{}
+		}
+	}
+	return types
+}
+
+// syncAutoTargets registers a target per enabled local service with all the running
+// destinations of the tunnel, and deregisters the targets of the disabled services.
+// The services already served by a configured target of the tunnel are skipped,
+// their registrations would share the same target state.
+func (a *app) syncAutoTargets(ctx context.Context, tn string, tun *tunnelCfg) {
+	desired := make(map[string]*target)
+	if tun.Tunnel.AutoTargets.AdminState == adminEnable {
+		configured := a.configuredTypes(tun)
+		a.config.sm.RLock()
+		for typ, ls := range a.config.services {
+			if _, ok := configured[typ]; ok {
+				continue
+			}
+			tg := new(target)
+			tg.Target.ID = tun.Tunnel.AutoTargets.ID
+			tg.Target.Type.Custom = []stringValue{{Value: typ}}
+			tg.Target.LocalAddress.Value = ls.Address
+			desired[autoTargetPrefix+strings.ToLower(typ)] = tg
+		}
+		a.config.sm.RUnlock()
+	}
+	if tun.Tunnel.AutoTarget == nil {
+		tun.Tunnel.AutoTarget = make(map[string]*target)
+	}
+	for name, tg := range tun.Tunnel.AutoTarget {
+		if dtg, ok := desired[name]; ok && reflect.DeepEqual(dtg, tg) {
+			delete(desired, name)
+			continue
+		}
+		a.tunnelLog(tn).WithField("target", name).Info("removing auto target")
+		for dn, dest := range tun.Tunnel.Destination {
+			if tdc, ok := a.tunnelClients[tn][dn]; ok && tdc.client != nil {
+				a.stopTunnelHandlerDestination(ctx, tn, name, dn, dest, tdc)
+			}
+		}
+		tg := name
+		a.closeSessions(func(s *session) bool {
+			return s.tunnel == tn && s.target == tg
+		}, closeReasonTargetDeleted, a.sessionDrainTime(tn), nil)
+		delete(tun.Tunnel.AutoTarget, name)
+	}
+	for name, tg := range desired {
+		a.tunnelLog(tn).WithField("target", name).Info("adding auto target")
+		tun.Tunnel.AutoTarget[name] = tg
+		for dn, dest := range tun.Tunnel.Destination {
+			if tdc, ok := a.tunnelClients[tn][dn]; ok && tdc.client != nil {
+				a.startTunnelHandlerDestination(ctx, tn, name, tg, dn, dest, tdc.client)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSyncAutoTargets(t *testing.T) {
+	services := map[string]*localService{
+		targetTypeGNMI: {Type: targetTypeGNMI, Address: gnmiServerUnixSocket, UnixSocket: true},
+		targetTypeSSH:  {Type: targetTypeSSH, Address: "localhost:22"},
+	}
+	tests := []struct {
+		name     string
+		disabled bool
+		// configured targets NDK JSON config, by name
+		targets map[string]string
+		// auto targets before the sync: name: local address
+		current map[string]string
+		// auto targets after the sync: name: local address
+		want map[string]string
+	}{
+		{
+			name: "all services",
+			want: map[string]string{
+				"auto:gnmi_gnoi": gnmiServerUnixSocket,
+				"auto:ssh":       "localhost:22",
+			},
+		},
+		{
+			name:     "disabled",
+			disabled: true,
+			current:  map[string]string{"auto:ssh": "localhost:22"},
+			want:     map[string]string{},
+		},
+		{
+			name:    "service served by a configured target",
+			targets: map[string]string{"gnmi": `{"target":{"type":{"grpc_server":{"value":true}}}}`},
+			want:    map[string]string{"auto:ssh": "localhost:22"},
+		},
+		{
+			name:    "service served by a disabled configured target",
+			targets: map[string]string{"gnmi": `{"target":{"admin_state":"ADMIN_STATE_disable","type":{"grpc_server":{"value":true}}}}`},
+			want: map[string]string{
+				"auto:gnmi_gnoi": gnmiServerUnixSocket,
+				"auto:ssh":       "localhost:22",
+			},
+		},
+		{
+			name: "stale and changed auto targets",
+			current: map[string]string{
+				"auto:netconf": "localhost:830",
+				"auto:ssh":     "localhost:2222",
+			},
+			want: map[string]string{
+				"auto:gnmi_gnoi": gnmiServerUnixSocket,
+				"auto:ssh":       "localhost:22",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp()
+			if !a.setServices(services) {
+				t.Fatal("setServices() = false, want true")
+			}
+			if a.setServices(services) {
+				t.Error("setServices() with the same services = true, want false")
+			}
+			tun := new(tunnelCfg)
+			tun.Tunnel.AutoTargets.AdminState = adminEnable
+			if tt.disabled {
+				tun.Tunnel.AutoTargets.AdminState = adminDisable
+			}
+			tun.Tunnel.Target = make(map[string]*target)
+			for name, js := range tt.targets {
+				tun.Tunnel.Target[name] = newTestTarget(t, js)
+			}
+			tun.Tunnel.AutoTarget = make(map[string]*target)
+			for name, addr := range tt.current {
+				tg := new(target)
+				tg.Target.LocalAddress.Value = addr
+				tun.Tunnel.AutoTarget[name] = tg
+			}
+			a.config.app.Tunnel["t1"] = tun
+			a.syncAutoTargets(context.Background(), "t1", tun)
+			got := make(map[string]string, len(tun.Tunnel.AutoTarget))
+			for name, tg := range tun.Tunnel.AutoTarget {
+				got[name] = tg.Target.LocalAddress.Value
+				ttds, err := a.targetTypes(tg)
+				if err != nil || len(ttds) != 1 || autoTargetPrefix+strings.ToLower(ttds[0].Type) != name {
+					t.Errorf("auto target %s types = %+v, %v", name, ttds, err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auto targets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
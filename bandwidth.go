@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type bandwidthCfg struct {
+	Rate  uint32Value `json:"rate,omitempty"`
+	Burst uint32Value `json:"burst,omitempty"`
+}
+
+// bandwidthLimit is a rate limit in bytes per second, 0 means unlimited.
+type bandwidthLimit struct {
+	rate  int
+	burst int
+}
+
+func newBandwidthLimit(bw bandwidthCfg) bandwidthLimit {
+	bl := bandwidthLimit{
+		rate:  int(bw.Rate.Value),
+		burst: int(bw.Burst.Value),
+	}
+	if bl.rate > 0 && bl.burst == 0 {
+		bl.burst = bl.rate
+	}
+	return bl
+}
+
+// shaper rate limits and measures the traffic in one direction.
+type shaper struct {
+	bytes atomic.Uint64
+
+	m          *sync.Mutex
+	limiter    *rate.Limiter
+	lastBytes  uint64
+	throughput uint64
+}
+
+func newShaper() *shaper {
+	return &shaper{m: new(sync.Mutex)}
+}
+
+func (s *shaper) setLimit(bl bandwidthLimit) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	switch {
+	case bl.rate == 0:
+		s.limiter = nil
+	case s.limiter == nil:
+		s.limiter = rate.NewLimiter(rate.Limit(bl.rate), bl.burst)
+	default:
+		s.limiter.SetLimit(rate.Limit(bl.rate))
+		s.limiter.SetBurst(bl.burst)
+	}
+}
+
+func (s *shaper) getLimiter() *rate.Limiter {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.limiter
+}
+
+// sample updates the throughput with the bytes sent since the last sample.
+func (s *shaper) sample(interval time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	b := s.bytes.Load()
+	s.throughput = uint64(float64(b-s.lastBytes) / interval.Seconds())
+	s.lastBytes = b
+}
+
+// clear resets the bytes counter and the throughput.
+func (s *shaper) clear() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.bytes.Store(0)
+	s.lastBytes = 0
+	s.throughput = 0
+}
+
+func (s *shaper) getThroughput() uint64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.throughput
+}
+
+// trafficShapers holds the shapers of both directions,
+// rx is the traffic received from the tunnel, tx the traffic sent to it.
+type trafficShapers struct {
+	rx *shaper
+	tx *shaper
+}
+
+func newTrafficShapers() trafficShapers {
+	return trafficShapers{rx: newShaper(), tx: newShaper()}
+}
+
+func (ts trafficShapers) setLimit(bl bandwidthLimit) {
+	ts.rx.setLimit(bl)
+	ts.tx.setLimit(bl)
+}
+
+func (ts trafficShapers) clear() {
+	ts.rx.clear()
+	ts.tx.clear()
+}
+
+func (ts trafficShapers) sample(interval time.Duration) {
+	ts.rx.sample(interval)
+	ts.tx.sample(interval)
+}
+
+type trafficStatistics struct {
+	RxBytes      uint64Value `json:"rx_bytes,omitempty"`
+	TxBytes      uint64Value `json:"tx_bytes,omitempty"`
+	RxThroughput uint64Value `json:"rx_throughput,omitempty"`
+	TxThroughput uint64Value `json:"tx_throughput,omitempty"`
+}
+
+func (ts trafficShapers) statistics() trafficStatistics {
+	return trafficStatistics{
+		RxBytes:      uint64Value{Value: ts.rx.bytes.Load()},
+		TxBytes:      uint64Value{Value: ts.tx.bytes.Load()},
+		RxThroughput: uint64Value{Value: ts.rx.getThroughput()},
+		TxThroughput: uint64Value{Value: ts.tx.getThroughput()},
+	}
+}
+
+// shapedStream wraps a tunnel session, applying and accounting
+// the traffic to each of the given shapers.
+// The reads and writes waiting for the shapers fail once ctx is done.
+type shapedStream struct {
+	io.ReadWriteCloser
+	ctx context.Context
+	rx  []*shaper
+	tx  []*shaper
+}
+
+func newShapedStream(ctx context.Context, rwc io.ReadWriteCloser, shapers ...trafficShapers) *shapedStream {
+	ss := &shapedStream{
+		ReadWriteCloser: rwc,
+		ctx:             ctx,
+		rx:              make([]*shaper, 0, len(shapers)),
+		tx:              make([]*shaper, 0, len(shapers)),
+	}
+	for _, s := range shapers {
+		ss.rx = append(ss.rx, s.rx)
+		ss.tx = append(ss.tx, s.tx)
+	}
+	return ss
+}
+
+func (s *shapedStream) Read(b []byte) (int, error) {
+	limiters := activeLimiters(s.rx)
+	if max := minBurst(limiters); max > 0 && len(b) > max {
+		b = b[:max]
+	}
+	n, err := s.ReadWriteCloser.Read(b)
+	for _, sh := range s.rx {
+		sh.bytes.Add(uint64(n))
+	}
+	for _, l := range limiters {
+		if werr := l.WaitN(s.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (s *shapedStream) Write(b []byte) (int, error) {
+	limiters := activeLimiters(s.tx)
+	chunk := minBurst(limiters)
+	if chunk == 0 {
+		chunk = len(b)
+	}
+	var written int
+	for written < len(b) {
+		end := written + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+		for _, l := range limiters {
+			if err := l.WaitN(s.ctx, end-written); err != nil {
+				return written, err
+			}
+		}
+		n, err := s.ReadWriteCloser.Write(b[written:end])
+		written += n
+		for _, sh := range s.tx {
+			sh.bytes.Add(uint64(n))
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func activeLimiters(shapers []*shaper) []*rate.Limiter {
+	limiters := make([]*rate.Limiter, 0, len(shapers))
+	for _, sh := range shapers {
+		if l := sh.getLimiter(); l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	return limiters
+}
+
+// minBurst returns the smallest burst of the limiters,
+// reads and writes are split to not exceed it.
+func minBurst(limiters []*rate.Limiter) int {
+	var min int
+	for _, l := range limiters {
+		if b := l.Burst(); min == 0 || b < min {
+			min = b
+		}
+	}
+	return min
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewBandwidthLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want bandwidthLimit
+	}{
+		{name: "unlimited", cfg: `{}`, want: bandwidthLimit{}},
+		{name: "burst defaults to the rate", cfg: `{"rate":{"value":1000}}`, want: bandwidthLimit{rate: 1000, burst: 1000}},
+		{name: "burst", cfg: `{"rate":{"value":1000},"burst":{"value":"64"}}`, want: bandwidthLimit{rate: 1000, burst: 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := newTestTarget(t, `{"target":{"bandwidth":`+tt.cfg+`}}`)
+			if got := newBandwidthLimit(tg.Target.Bandwidth); got != tt.want {
+				t.Errorf("newBandwidthLimit() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// chunkRecorder records the size of each write.
+type chunkRecorder struct {
+	bytes.Buffer
+	writes []int
+}
+
+func (c *chunkRecorder) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, len(b))
+	return c.Buffer.Write(b)
+}
+
+func (c *chunkRecorder) Close() error { return nil }
+
+func TestShapedStreamWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		// tunnel and target limits
+		limits     []bandwidthLimit
+		size       int
+		wantWrites []int
+	}{
+		{
+			name:       "unlimited",
+			limits:     []bandwidthLimit{{}, {}},
+			size:       100,
+			wantWrites: []int{100},
+		},
+		{
+			name:       "split to the burst",
+			limits:     []bandwidthLimit{{rate: 1 << 20, burst: 40}, {}},
+			size:       100,
+			wantWrites: []int{40, 40, 20},
+		},
+		{
+			name:       "split to the smallest burst",
+			limits:     []bandwidthLimit{{rate: 1 << 20, burst: 60}, {rate: 1 << 20, burst: 50}},
+			size:       100,
+			wantWrites: []int{50, 50},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shapers := make([]trafficShapers, 0, len(tt.limits))
+			for _, bl := range tt.limits {
+				ts := newTrafficShapers()
+				ts.setLimit(bl)
+				shapers = append(shapers, ts)
+			}
+			rec := new(chunkRecorder)
+			ss := newShapedStream(context.Background(), rec, shapers...)
+			n, err := ss.Write(make([]byte, tt.size))
+			if err != nil || n != tt.size {
+				t.Fatalf("Write() = %d, %v, want %d, nil", n, err, tt.size)
+			}
+			if len(rec.writes) != len(tt.wantWrites) {
+				t.Fatalf("writes = %v, want %v", rec.writes, tt.wantWrites)
+			}
+			for i := range rec.writes {
+				if rec.writes[i] != tt.wantWrites[i] {
+					t.Fatalf("writes = %v, want %v", rec.writes, tt.wantWrites)
+				}
+			}
+			for i, ts := range shapers {
+				if got := ts.tx.bytes.Load(); got != uint64(tt.size) {
+					t.Errorf("shaper %d: tx bytes = %d, want %d", i, got, tt.size)
+				}
+			}
+		})
+	}
+}
+
+func TestShapedStreamRead(t *testing.T) {
+	ts := newTrafficShapers()
+	ts.setLimit(bandwidthLimit{rate: 1 << 20, burst: 16})
+	src := new(chunkRecorder)
+	src.Buffer.Write(make([]byte, 100))
+	ss := newShapedStream(context.Background(), src, ts)
+	b := make([]byte, 100)
+	n, err := ss.Read(b)
+	if err != nil || n != 16 {
+		t.Fatalf("Read() = %d, %v, want 16, nil", n, err)
+	}
+	if got := ts.rx.bytes.Load(); got != 16 {
+		t.Errorf("rx bytes = %d, want 16", got)
+	}
+}
+
+func TestShapedStreamCanceled(t *testing.T) {
+	ts := newTrafficShapers()
+	// one byte per second, the second write waits for the limiter
+	ts.setLimit(bandwidthLimit{rate: 1, burst: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	ss := newShapedStream(ctx, new(chunkRecorder), ts)
+	if _, err := ss.Write([]byte{0}); err != nil {
+		t.Fatalf("first Write() error = %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	n, err := ss.Write([]byte{0, 0})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Write() error = %v, want %v", err, context.Canceled)
+	}
+	if n != 0 {
+		t.Errorf("Write() = %d, want 0", n)
+	}
+	if d := time.Since(start); d > 900*time.Millisecond {
+		t.Errorf("Write() returned after %s, want it to return on cancel", d)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/nokia/srlinux-ndk-go/ndk"
@@ -36,44 +38,74 @@ type config struct {
 	sysInfo  systemInfo
 	username string
 	password string
+	// discovered local services, indexed by target type
+	sm       *sync.RWMutex
+	services map[string]*localService
 }
 
 type appConfig struct {
 	AdminState string `json:"admin_state,omitempty"`
 	OperState  string `json:"oper_state,omitempty"`
+	LogLevel   string `json:"log_level,omitempty"`
+	//
+	AuditLog auditLogCfg `json:"audit_log,omitempty"`
 	//
 	Destination map[string]*destination `json:"-"`
 	Tunnel      map[string]*tunnelCfg   `json:"-"`
+	Webhook     map[string]*webhookCfg  `json:"-"`
 }
 
 type destination struct {
 	Destination struct {
-		Address         stringValue `json:"address,omitempty"`
-		Port            stringValue `json:"port,omitempty"`
-		Description     stringValue `json:"description,omitempty"`
-		NoTLS           boolValue   `json:"no_tls,omitempty"`
-		TLSProfile      stringValue `json:"tls_profile,omitempty"`
-		NetworkInstance stringValue `json:"network_instance,omitempty"`
+		AdminState      string       `json:"admin_state,omitempty"`
+		Address         stringValue  `json:"address,omitempty"`
+		Port            stringValue  `json:"port,omitempty"`
+		Description     stringValue  `json:"description,omitempty"`
+		NoTLS           boolValue    `json:"no_tls,omitempty"`
+		TLSProfile      stringValue  `json:"tls_profile,omitempty"`
+		NetworkInstance stringValue  `json:"network_instance,omitempty"`
+		Dampening       dampeningCfg `json:"dampening,omitempty"`
+		Transport       transportCfg `json:"transport,omitempty"`
+		Socket          socketCfg    `json:"socket,omitempty"`
 	} `json:"destination,omitempty"`
 }
 
 type tunnelCfg struct {
 	Tunnel struct {
-		AdminState          string      `json:"admin_state,omitempty"`
-		OperState           string      `json:"oper_state,omitempty"`
-		OperStateDownReason stringValue `json:"oper_state_down_reason,omitempty"`
-		Description         stringValue `json:"description,omitempty"`
+		AdminState          string       `json:"admin_state,omitempty"`
+		OperState           string       `json:"oper_state,omitempty"`
+		OperStateDownReason stringValue  `json:"oper_state_down_reason,omitempty"`
+		Description         stringValue  `json:"description,omitempty"`
+		Bandwidth           bandwidthCfg `json:"bandwidth,omitempty"`
+		SessionDrainTime    uint32Value  `json:"session_drain_time,omitempty"`
+		LogLevel            string       `json:"log_level,omitempty"`
+		AutoTargets         struct {
+			AdminState string   `json:"admin_state,omitempty"`
+			ID         targetID `json:"id,omitempty"`
+		} `json:"auto_targets,omitempty"`
 
 		Target      map[string]*target           `json:"-"`
 		Destination map[string]*destinationState `json:"-"`
+		// targets created from the enabled local services
+		AutoTarget map[string]*target `json:"-"`
 	} `json:"tunnel,omitempty"`
 }
 
 type destinationState struct {
-	OperState           string      `json:"oper_state,omitempty"`
-	OperStateDownReason stringValue `json:"oper_state_down_reason,omitempty"`
+	AdminState          string          `json:"admin_state,omitempty"`
+	OperState           string          `json:"oper_state,omitempty"`
+	OperStateDownReason stringValue     `json:"oper_state_down_reason,omitempty"`
+	Dampening           *dampeningState `json:"dampening,omitempty"`
 
 	Target map[string]*targetState `json:"-"`
+	damper *flapDamper
+}
+
+// tunnelDestinationCfg is the config of a destination reference under a tunnel.
+type tunnelDestinationCfg struct {
+	Destination struct {
+		AdminState string `json:"admin_state,omitempty"`
+	} `json:"destination,omitempty"`
 }
 
 type targetState struct {
@@ -85,21 +117,58 @@ type targetState struct {
 
 type target struct {
 	Target struct {
-		LocalAddress stringValue `json:"local_address,omitempty"`
-		ID           struct {
-			NodeName   *boolValue   `json:"node_name,omitempty"`
-			UserAgent  *boolValue   `json:"user_agent,omitempty"`
-			MacAddress *boolValue   `json:"mac_address,omitempty"`
-			Custom     *stringValue `json:"custom,omitempty"`
-		} `json:"id,omitempty"`
-		Type struct {
-			GrpcServer *boolValue   `json:"grpc_server,omitempty"`
-			SSHServer  *boolValue   `json:"ssh_server,omitempty"`
-			Custom     *stringValue `json:"custom,omitempty"`
+		AdminState          string      `json:"admin_state,omitempty"`
+		OperState           string      `json:"oper_state,omitempty"`
+		OperStateDownReason stringValue `json:"oper_state_down_reason,omitempty"`
+		LocalAddress        stringValue `json:"local_address,omitempty"`
+		DSCP                uint32Value `json:"dscp,omitempty"`
+		ID                  targetID    `json:"id,omitempty"`
+		Type                struct {
+			GrpcServer    *boolValue    `json:"grpc_server,omitempty"`
+			SSHServer     *boolValue    `json:"ssh_server,omitempty"`
+			GribiServer   *boolValue    `json:"gribi_server,omitempty"`
+			P4rtServer    *boolValue    `json:"p4rt_server,omitempty"`
+			JSONRPCServer *boolValue    `json:"json_rpc_server,omitempty"`
+			NetconfServer *boolValue    `json:"netconf_server,omitempty"`
+			Custom        []stringValue `json:"custom,omitempty"`
 		} `json:"type,omitempty"`
+		Exec struct {
+			Command          stringValue   `json:"command,omitempty"`
+			Args             []stringValue `json:"args,omitempty"`
+			User             stringValue   `json:"user,omitempty"`
+			Environment      []stringValue `json:"environment,omitempty"`
+			WorkingDirectory stringValue   `json:"working_directory,omitempty"`
+			Timeout          uint32Value   `json:"timeout,omitempty"`
+		} `json:"exec,omitempty"`
+		GrpcProxy struct {
+			AdminState string      `json:"admin_state,omitempty"`
+			Username   stringValue `json:"username,omitempty"`
+			Password   stringValue `json:"password,omitempty"`
+			LocalTLS   boolValue   `json:"local_tls,omitempty"`
+		} `json:"grpc_proxy,omitempty"`
+		RPCPolicy struct {
+			AllowMethod    []stringValue `json:"allow_method,omitempty"`
+			DenyMethod     []stringValue `json:"deny_method,omitempty"`
+			GnmiPathPrefix []stringValue `json:"gnmi_path_prefix,omitempty"`
+		} `json:"rpc_policy,omitempty"`
+		SessionLimits struct {
+			MaxSessions uint32Value `json:"max_sessions,omitempty"`
+			Rate        uint32Value `json:"rate,omitempty"`
+			Burst       uint32Value `json:"burst,omitempty"`
+			IdleTimeout uint32Value `json:"idle_timeout,omitempty"`
+			MaxLifetime uint32Value `json:"max_lifetime,omitempty"`
+		} `json:"session_limits,omitempty"`
+		Bandwidth bandwidthCfg `json:"bandwidth,omitempty"`
 	} `json:"target,omitempty"`
 }
 
+type targetID struct {
+	NodeName   *boolValue    `json:"node_name,omitempty"`
+	UserAgent  *boolValue    `json:"user_agent,omitempty"`
+	MacAddress *boolValue    `json:"mac_address,omitempty"`
+	Custom     []stringValue `json:"custom,omitempty"`
+}
+
 type stringValue struct {
 	Value string `json:"value,omitempty"`
 }
@@ -108,10 +177,38 @@ type boolValue struct {
 	Value bool `json:"value,omitempty"`
 }
 
+type uint32Value struct {
+	Value uint32 `json:"value,omitempty"`
+}
+
+// UnmarshalJSON accepts both numbers and quoted numbers as value.
+func (v *uint32Value) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Value json.RawMessage `json:"value,omitempty"`
+	}
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return err
+	}
+	if len(raw.Value) == 0 || string(raw.Value) == "null" {
+		v.Value = 0
+		return nil
+	}
+	n, err := strconv.ParseUint(strings.Trim(string(raw.Value), `"`), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid uint32 value %s: %v", raw.Value, err)
+	}
+	v.Value = uint32(n)
+	return nil
+}
+
 func newConfig() *config {
 	return &config{
 		m:   new(sync.Mutex),
 		trx: make([]*ndk.ConfigNotification, 0),
+		//
+		sm:       new(sync.RWMutex),
+		services: make(map[string]*localService),
 		app: &appConfig{
 			Destination: make(map[string]*destination),
 			Tunnel:      make(map[string]*tunnelCfg),
@@ -141,6 +238,17 @@ func (a *app) handleConfigEvent(ctx context.Context, cfg *ndk.ConfigNotification
 			a.handleTunnelTarget(ctx, txCfg)
 		case tunnelDestinationPath:
 			a.handleTunnelDestination(ctx, txCfg)
+		case webhookPath:
+			a.handleWebhook(ctx, txCfg)
+		// tools commands
+		case toolsTunnelPath:
+			a.handleToolsTunnel(ctx, txCfg)
+		case toolsTunnelDestinationPath:
+			a.handleToolsTunnelDestination(ctx, txCfg)
+		case toolsTunnelTargetPath:
+			a.handleToolsTunnelTarget(ctx, txCfg)
+		case toolsSessionPath:
+			a.handleToolsSession(ctx, txCfg)
 		default:
 			log.Errorf("received unexpected config path %q", txCfg.GetKey().GetJsPath())
 		}
@@ -157,13 +265,13 @@ func (a *app) handleNwInstCfg(ctx context.Context, cfg *ndk.NetworkInstanceNotif
 func (a *app) handleGrpcTunnel(ctx context.Context, txCfg *ndk.ConfigNotification) {
 	switch txCfg.GetOp() {
 	case ndk.SdkMgrOperation_Create:
-		log.Infof("Create: .system.grpc_tunnel: %+v", txCfg)
+		log.Debugf("Create: .system.grpc_tunnel: %+v", txCfg)
 		a.handleGrpcTunnelCreate(ctx, txCfg.GetData())
 	case ndk.SdkMgrOperation_Update:
-		log.Infof("Update: .system.grpc_tunnel: %+v", txCfg)
+		log.Debugf("Update: .system.grpc_tunnel: %+v", txCfg)
 		a.handleGrpcTunnelChange(ctx, txCfg.GetData())
 	case ndk.SdkMgrOperation_Delete:
-		log.Infof("Delete: .system.grpc_tunnel: %+v", txCfg)
+		log.Debugf("Delete: .system.grpc_tunnel: %+v", txCfg)
 		a.handleGrpcTunnelDelete(ctx)
 	}
 }
@@ -177,6 +285,8 @@ func (a *app) handleGrpcTunnelCreate(ctx context.Context, cfgData *ndk.ConfigDat
 	}
 	a.config.app = newAppCfg
 	a.config.app.OperState = operDown
+	a.setLogLevel(newAppCfg.LogLevel)
+	a.setAuditLog(newAppCfg.AuditLog)
 	a.updateRootLevelTelemetry(a.config.app)
 }
 
@@ -189,6 +299,12 @@ func (a *app) handleGrpcTunnelChange(ctx context.Context, cfgData *ndk.ConfigDat
 		return
 	}
 	a.config.app.AdminState = newAppCfg.AdminState
+	if a.config.app.LogLevel != newAppCfg.LogLevel {
+		a.config.app.LogLevel = newAppCfg.LogLevel
+		a.setLogLevel(newAppCfg.LogLevel)
+	}
+	a.config.app.AuditLog = newAppCfg.AuditLog
+	a.setAuditLog(newAppCfg.AuditLog)
 	// apply state change
 	switch {
 	case a.config.app.AdminState == adminDisable && a.config.app.OperState == operUp:
@@ -212,6 +328,9 @@ func (a *app) handleGrpcTunnelDelete(ctx context.Context) {
 		Destination: make(map[string]*destination),
 		Tunnel:      make(map[string]*tunnelCfg),
 	}
+	a.setLogLevel("")
+	a.setAuditLog(auditLogCfg{})
+	a.stopWebhooks()
 	a.updateRootLevelTelemetry(a.config.app)
 	a.m.Lock()
 	a.tunnelClients = make(map[string]map[string]*tunnelDestinationClient)
@@ -240,7 +359,7 @@ func (a *app) handleDestinationCreate(ctx context.Context, dName string, cfgData
 	newDG := new(destination)
 	err := json.Unmarshal([]byte(cfgData.GetJson()), newDG)
 	if err != nil {
-		log.Errorf("failed to unmarshal path %q config %+v", destinationPath, cfgData)
+		log.WithField("destination", dName).Errorf("failed to unmarshal path %q config %+v", destinationPath, cfgData)
 		return
 	}
 	if a.config.app.Destination == nil {
@@ -254,14 +373,32 @@ func (a *app) handleDestinationChange(ctx context.Context, dName string, cfgData
 	newDest := new(destination)
 	err := json.Unmarshal([]byte(cfgData.GetJson()), newDest)
 	if err != nil {
-		log.Errorf("failed to unmarshal path %q config %+v", destinationPath, cfgData)
+		log.WithField("destination", dName).Errorf("failed to unmarshal path %q config %+v", destinationPath, cfgData)
 		return
 	}
 	if a.config.app.Destination == nil {
 		a.config.app.Destination = make(map[string]*destination)
 	}
+	oldDest := a.config.app.Destination[dName]
 	a.config.app.Destination[dName] = newDest
 	a.updateDestinationTelemetry(dName, newDest)
+	if oldDest == nil || oldDest.Destination.AdminState == newDest.Destination.AdminState {
+		return
+	}
+	// apply the admin-state change to the tunnels using the destination
+	for tn, tun := range a.config.app.Tunnel {
+		destState, ok := tun.Tunnel.Destination[dName]
+		if !ok {
+			continue
+		}
+		if newDest.Destination.AdminState == adminDisable {
+			a.stopDestination(ctx, tn, dName, destState, "destination admin down")
+			continue
+		}
+		if tun.Tunnel.AdminState == adminEnable && a.config.app.AdminState == adminEnable {
+			a.startDestination(ctx, tn, dName, tun, destState)
+		}
+	}
 }
 
 func (a *app) handleDestinationDelete(ctx context.Context, dName string) {
@@ -273,7 +410,7 @@ func (a *app) handleDestinationDelete(ctx context.Context, dName string) {
 func (a *app) handleTunnel(ctx context.Context, txCfg *ndk.ConfigNotification) {
 	keys := txCfg.GetKey().GetKeys()
 	if len(keys) != 1 {
-		log.Errorf("unexpected number of keys in path %q: %v: %+v", destinationPath, keys, txCfg)
+		log.Errorf("unexpected number of keys in path %q: %v: %+v", tunnelPath, keys, txCfg)
 		return
 	}
 	tn := keys[0]
@@ -291,7 +428,7 @@ func (a *app) handleTunnelCreate(ctx context.Context, tn string, cfgData *ndk.Co
 	newTunnel := new(tunnelCfg)
 	err := json.Unmarshal([]byte(cfgData.GetJson()), newTunnel)
 	if err != nil {
-		log.Errorf("failed to unmarshal path %q config %+v", tunnelPath, cfgData)
+		a.tunnelLog(tn).Errorf("failed to unmarshal path %q config %+v", tunnelPath, cfgData)
 		return
 	}
 	if a.config.app.Tunnel == nil {
@@ -301,7 +438,10 @@ func (a *app) handleTunnelCreate(ctx context.Context, tn string, cfgData *ndk.Co
 	if newTunnel.Tunnel.AdminState == adminDisable {
 		newTunnel.Tunnel.OperState = operDown
 	}
+	a.setTunnelLogLevel(tn, newTunnel.Tunnel.LogLevel)
 	a.config.app.Tunnel[tn] = newTunnel
+	a.getTunnelStats(tn).traffic.setLimit(newBandwidthLimit(newTunnel.Tunnel.Bandwidth))
+	a.syncAutoTargets(ctx, tn, newTunnel)
 	a.updateTunnelTelemetry(tn, newTunnel)
 }
 
@@ -309,7 +449,7 @@ func (a *app) handleTunnelChange(ctx context.Context, tn string, cfgData *ndk.Co
 	newTunnel := new(tunnelCfg)
 	err := json.Unmarshal([]byte(cfgData.GetJson()), newTunnel)
 	if err != nil {
-		log.Errorf("failed to unmarshal path %q config %+v", tunnelPath, cfgData)
+		a.tunnelLog(tn).Errorf("failed to unmarshal path %q config %+v", tunnelPath, cfgData)
 		return
 	}
 	if a.config.app.Tunnel == nil {
@@ -318,13 +458,16 @@ func (a *app) handleTunnelChange(ctx context.Context, tn string, cfgData *ndk.Co
 	if tun, ok := a.config.app.Tunnel[tn]; ok {
 		newTunnel.Tunnel.Target = tun.Tunnel.Target
 		newTunnel.Tunnel.Destination = tun.Tunnel.Destination
+		newTunnel.Tunnel.AutoTarget = tun.Tunnel.AutoTarget
 	}
-	log.Infof("tunnel %s, new admin-state=%s, oper-state=%s", tn, newTunnel.Tunnel.AdminState, a.config.app.Tunnel[tn].Tunnel.OperState)
+	a.setTunnelLogLevel(tn, newTunnel.Tunnel.LogLevel)
+	tlog := a.tunnelLog(tn)
+	tlog.Infof("new admin-state=%s, oper-state=%s", newTunnel.Tunnel.AdminState, a.config.app.Tunnel[tn].Tunnel.OperState)
 	switch {
 	case newTunnel.Tunnel.AdminState == adminEnable && a.config.app.Tunnel[tn].Tunnel.OperState != operUp:
 		err := a.startTunnel(ctx, tn, newTunnel)
 		if err != nil {
-			log.Errorf("failed to start tunnel %s: %v", tn, err)
+			tlog.Errorf("failed to start tunnel: %v", err)
 		}
 		newTunnel.Tunnel.OperState = operUp
 		newTunnel.Tunnel.OperStateDownReason.Value = ""
@@ -336,6 +479,8 @@ func (a *app) handleTunnelChange(ctx context.Context, tn string, cfgData *ndk.Co
 		}
 	}
 	a.config.app.Tunnel[tn] = newTunnel
+	a.getTunnelStats(tn).traffic.setLimit(newBandwidthLimit(newTunnel.Tunnel.Bandwidth))
+	a.syncAutoTargets(ctx, tn, newTunnel)
 	a.updateTunnelTelemetry(tn, newTunnel)
 }
 
@@ -343,7 +488,9 @@ func (a *app) handleTunnelDelete(ctx context.Context, tn string) {
 	// stop all destinations of tunnel
 	a.stopTunnel(ctx, tn)
 	delete(a.config.app.Tunnel, tn)
+	a.deleteTunnelStats(tn)
 	a.deleteTunnelTelemetry(ctx, tn)
+	a.deleteTunnelLog(tn)
 }
 
 // ".system.grpc_tunnel.tunnel.destination" handlers
@@ -366,12 +513,16 @@ func (a *app) handleTunnelDestination(ctx context.Context, txCfg *ndk.ConfigNoti
 }
 
 func (a *app) handleTunnelDestinationCreate(ctx context.Context, tn, dn string, cfgData *ndk.ConfigData) {
-	newDstState := new(destinationState)
-	err := json.Unmarshal([]byte(cfgData.GetJson()), newDstState)
+	newDstCfg := new(tunnelDestinationCfg)
+	err := json.Unmarshal([]byte(cfgData.GetJson()), newDstCfg)
 	if err != nil {
-		log.Errorf("failed to unmarshal path %q config %+v", tunnelDestinationPath, cfgData)
+		a.tunnelLog(tn).WithField("destination", dn).Errorf("failed to unmarshal path %q config %+v", tunnelDestinationPath, cfgData)
 		return
 	}
+	newDstState := &destinationState{
+		AdminState: newDstCfg.Destination.AdminState,
+		damper:     newFlapDamper(),
+	}
 	if _, ok := a.config.app.Tunnel[tn]; !ok {
 		a.config.app.Tunnel[tn] = new(tunnelCfg)
 	}
@@ -380,15 +531,37 @@ func (a *app) handleTunnelDestinationCreate(ctx context.Context, tn, dn string,
 	}
 	tun := a.config.app.Tunnel[tn]
 	tun.Tunnel.Destination[dn] = newDstState
-	dest := a.config.app.Destination[dn]
 	a.updateTunnelDestinationTelemetry(tn, dn, newDstState)
 	if tun.Tunnel.AdminState == adminEnable {
-		go a.startTunnelDestination(ctx, tn, dn, tun, dest, newDstState)
+		a.startDestination(ctx, tn, dn, tun, newDstState)
 	}
 }
 
-// won't happen until there is config under .grpc_tunnel.tunnel.destination
 func (a *app) handleTunnelDestinationChange(ctx context.Context, tn, dn string, cfgData *ndk.ConfigData) {
+	newDstCfg := new(tunnelDestinationCfg)
+	err := json.Unmarshal([]byte(cfgData.GetJson()), newDstCfg)
+	if err != nil {
+		a.tunnelLog(tn).WithField("destination", dn).Errorf("failed to unmarshal path %q config %+v", tunnelDestinationPath, cfgData)
+		return
+	}
+	tun, ok := a.config.app.Tunnel[tn]
+	if !ok {
+		return
+	}
+	destState, ok := tun.Tunnel.Destination[dn]
+	if !ok || destState.AdminState == newDstCfg.Destination.AdminState {
+		return
+	}
+	destState.AdminState = newDstCfg.Destination.AdminState
+	if destState.AdminState == adminDisable {
+		a.stopDestination(ctx, tn, dn, destState, "admin down")
+		return
+	}
+	if tun.Tunnel.AdminState == adminEnable && a.config.app.AdminState == adminEnable {
+		a.startDestination(ctx, tn, dn, tun, destState)
+		return
+	}
+	a.updateTunnelDestinationTelemetry(tn, dn, destState)
 }
 
 func (a *app) handleTunnelDestinationDelete(ctx context.Context, tn, dn string) {
@@ -399,10 +572,9 @@ func (a *app) handleTunnelDestinationDelete(ctx context.Context, tn, dn string)
 
 // ".system.grpc_tunnel.tunnel.target" handlers
 func (a *app) handleTunnelTarget(ctx context.Context, txCfg *ndk.ConfigNotification) {
-	fmt.Printf("handler config: %v\n", txCfg.GetOp())
 	keys := txCfg.GetKey().GetKeys()
 	if len(keys) != 2 {
-		log.Errorf("unexpected number of keys in path %q: %v: %+v", destinationPath, keys, txCfg)
+		log.Errorf("unexpected number of keys in path %q: %v: %+v", tunnelTargetPath, keys, txCfg)
 		return
 	}
 	tn := keys[0]
@@ -421,7 +593,7 @@ func (a *app) handleTunnelTargetCreate(ctx context.Context, tn, tg string, cfgDa
 	newTarget := new(target)
 	err := json.Unmarshal([]byte(cfgData.GetJson()), newTarget)
 	if err != nil {
-		log.Errorf("failed to unmarshal path %q config %+v", tunnelTargetPath, cfgData)
+		a.tunnelLog(tn).WithField("target", tg).Errorf("failed to unmarshal path %q config %+v", tunnelTargetPath, cfgData)
 		return
 	}
 	if _, ok := a.config.app.Tunnel[tn]; !ok {
@@ -430,11 +602,16 @@ func (a *app) handleTunnelTargetCreate(ctx context.Context, tn, tg string, cfgDa
 	if a.config.app.Tunnel[tn].Tunnel.Target == nil {
 		a.config.app.Tunnel[tn].Tunnel.Target = make(map[string]*target)
 	}
+	setTargetOperState(newTarget)
 	if _, ok := a.config.app.Tunnel[tn]; ok {
 		a.config.app.Tunnel[tn].Tunnel.Target[tg] = newTarget
-		for dn, dest := range a.config.app.Tunnel[tn].Tunnel.Destination {
-			if ttd, ok := a.tunnelClients[tn][dn]; ok {
-				a.startTunnelHandlerDestination(ctx, tn, tg, newTarget, dn, dest, ttd.client)
+		// remove the auto targets of the types now served by the target
+		a.syncAutoTargets(ctx, tn, a.config.app.Tunnel[tn])
+		if newTarget.Target.AdminState != adminDisable {
+			for dn, dest := range a.config.app.Tunnel[tn].Tunnel.Destination {
+				if ttd, ok := a.tunnelClients[tn][dn]; ok && ttd.client != nil {
+					a.startTunnelHandlerDestination(ctx, tn, tg, newTarget, dn, dest, ttd.client)
+				}
 			}
 		}
 	}
@@ -446,37 +623,74 @@ func (a *app) handleTunnelTargetChange(ctx context.Context, tn, tg string, cfgDa
 	newTarget := new(target)
 	err := json.Unmarshal([]byte(cfgData.GetJson()), newTarget)
 	if err != nil {
-		log.Errorf("failed to unmarshal path %q config %+v", tunnelTargetPath, cfgData)
+		a.tunnelLog(tn).WithField("target", tg).Errorf("failed to unmarshal path %q config %+v", tunnelTargetPath, cfgData)
 		return
 	}
 	if a.config.app.Tunnel[tn].Tunnel.Target == nil {
 		a.config.app.Tunnel[tn].Tunnel.Target = make(map[string]*target)
 	}
+	setTargetOperState(newTarget)
 	if tun, ok := a.config.app.Tunnel[tn]; ok {
 		tun.Tunnel.Target[tg] = newTarget
+		// stop target
 		for dn, dest := range tun.Tunnel.Destination {
-			if tdc, ok := a.tunnelClients[tn][dn]; ok {
-				// stop target
+			if tdc, ok := a.tunnelClients[tn][dn]; ok && tdc.client != nil {
 				a.stopTunnelHandlerDestination(ctx, tn, tg, dn, dest, tdc)
-				delete(tdc.targets, tg)
-				// start again
-				a.startTunnelHandlerDestination(ctx, tn, tg, newTarget, dn, dest, tdc.client)
 			}
 		}
+		// swap the auto targets of the types the target now serves or no longer serves
+		a.syncAutoTargets(ctx, tn, tun)
+		if newTarget.Target.AdminState != adminDisable {
+			// start again
+			for dn, dest := range tun.Tunnel.Destination {
+				if tdc, ok := a.tunnelClients[tn][dn]; ok && tdc.client != nil {
+					a.startTunnelHandlerDestination(ctx, tn, tg, newTarget, dn, dest, tdc.client)
+				}
+			}
+		}
+	}
+	if newTarget.Target.AdminState == adminDisable {
+		a.closeSessions(func(s *session) bool {
+			return s.tunnel == tn && s.target == tg
+		}, closeReasonTargetDisabled, a.sessionDrainTime(tn), nil)
 	}
 	a.updateTunnelTargetTelemetry(tn, tg, newTarget)
 }
 
+// setTargetOperState sets the target oper-state from its admin-state,
+// an invalid target is down.
+func setTargetOperState(tg *target) {
+	if tg.Target.AdminState == adminDisable {
+		tg.Target.OperState = operDown
+		tg.Target.OperStateDownReason.Value = "admin down"
+		return
+	}
+	if err := checkTarget(tg); err != nil {
+		tg.Target.OperState = operDown
+		tg.Target.OperStateDownReason.Value = fmt.Sprintf("invalid configuration: %v", err)
+		return
+	}
+	tg.Target.OperState = operUp
+	tg.Target.OperStateDownReason.Value = ""
+}
+
 func (a *app) handleTunnelTargetDelete(ctx context.Context, tn, tg string) {
 	if tun, ok := a.config.app.Tunnel[tn]; ok {
 		for dn, dest := range tun.Tunnel.Destination {
 			if tdc, ok := a.tunnelClients[tn][dn]; ok {
 				a.stopTunnelHandlerDestination(ctx, tn, tg, dn, dest, tdc)
-				delete(tdc.targets, tg)
 			}
 		}
 	}
 
-	delete(a.config.app.Tunnel[tn].Tunnel.Target, tg)
+	a.closeSessions(func(s *session) bool {
+		return s.tunnel == tn && s.target == tg
+	}, closeReasonTargetDeleted, a.sessionDrainTime(tn), nil)
+	if tun, ok := a.config.app.Tunnel[tn]; ok {
+		delete(tun.Tunnel.Target, tg)
+		// add back the auto targets of the types the target served
+		a.syncAutoTargets(ctx, tn, tun)
+	}
+	a.deleteTargetStats(tn, tg)
 	a.deleteTunnelTargetTelemetry(tn, tg)
 }
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	"github.com/openconfig/grpctunnel/tunnel"
+)
+
+// testConfigData returns the NDK config data of the given JSON.
+func testConfigData(js string) *ndk.ConfigData {
+	return &ndk.ConfigData{DataType: &ndk.ConfigData_Json{Json: js}}
+}
+
+// newTestAdminStateApp returns an enabled app with tunnel t1 using destination d1,
+// both enabled and not connected.
+func newTestAdminStateApp() (*app, *destinationState) {
+	a := newTestApp()
+	a.config.app.AdminState = adminEnable
+	dest := new(destination)
+	dest.Destination.AdminState = adminEnable
+	a.config.app.Destination["d1"] = dest
+	tun := new(tunnelCfg)
+	tun.Tunnel.AdminState = adminEnable
+	destState := &destinationState{AdminState: adminEnable, Target: make(map[string]*targetState)}
+	tun.Tunnel.Destination = map[string]*destinationState{"d1": destState}
+	tun.Tunnel.Target = make(map[string]*target)
+	a.config.app.Tunnel["t1"] = tun
+	return a, destState
+}
+
+func TestDestinationAdminState(t *testing.T) {
+	const (
+		tunnelDestEnable  = `{"destination":{"admin_state":"ADMIN_STATE_enable"}}`
+		tunnelDestDisable = `{"destination":{"admin_state":"ADMIN_STATE_disable"}}`
+		destEnable        = `{"destination":{"admin_state":"ADMIN_STATE_enable","address":{"value":"10.0.0.1"}}}`
+		destDisable       = `{"destination":{"admin_state":"ADMIN_STATE_disable","address":{"value":"10.0.0.1"}}}`
+	)
+	type change struct {
+		// tunnel destination or destination config
+		tunnelDest string
+		dest       string
+		wantState  string
+		wantReason string
+		// a connection to the destination is started
+		wantRunning bool
+	}
+	tests := []struct {
+		name string
+		// the tunnel is disabled
+		tunnelDisabled bool
+		changes        []change
+	}{
+		{
+			name: "tunnel destination disabled then enabled",
+			changes: []change{
+				{tunnelDest: tunnelDestDisable, wantState: operDown, wantReason: "admin down"},
+				{tunnelDest: tunnelDestDisable, wantState: operDown, wantReason: "admin down"},
+				{tunnelDest: tunnelDestEnable, wantState: operStarting, wantRunning: true},
+				{tunnelDest: tunnelDestDisable, wantState: operDown, wantReason: "admin down"},
+			},
+		},
+		{
+			name: "destination disabled then enabled",
+			changes: []change{
+				{tunnelDest: tunnelDestDisable, wantState: operDown, wantReason: "admin down"},
+				{tunnelDest: tunnelDestEnable, wantState: operStarting, wantRunning: true},
+				{dest: destDisable, wantState: operDown, wantReason: "destination admin down"},
+				{dest: destDisable, wantState: operDown, wantReason: "destination admin down"},
+				{tunnelDest: tunnelDestDisable, wantState: operDown, wantReason: "admin down"},
+				// the tunnel destination stays down while the destination is disabled
+				{tunnelDest: tunnelDestEnable, wantState: operDown, wantReason: "destination admin down"},
+				{dest: destEnable, wantState: operStarting, wantRunning: true},
+			},
+		},
+		{
+			name:           "tunnel disabled",
+			tunnelDisabled: true,
+			changes: []change{
+				{tunnelDest: tunnelDestDisable, wantState: operDown, wantReason: "admin down"},
+				{tunnelDest: tunnelDestEnable, wantState: operDown, wantReason: "admin down"},
+				{dest: destDisable, wantState: operDown, wantReason: "destination admin down"},
+				{dest: destEnable, wantState: operDown, wantReason: "destination admin down"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, destState := newTestAdminStateApp()
+			if tt.tunnelDisabled {
+				a.config.app.Tunnel["t1"].Tunnel.AdminState = adminDisable
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for i, c := range tt.changes {
+				if c.tunnelDest != "" {
+					a.handleTunnelDestinationChange(ctx, "t1", "d1", testConfigData(c.tunnelDest))
+				} else {
+					a.handleDestinationChange(ctx, "d1", testConfigData(c.dest))
+				}
+				if destState.OperState != c.wantState || destState.OperStateDownReason.Value != c.wantReason {
+					t.Errorf("change %d: oper-state = %s (%q), want %s (%q)",
+						i+1, destState.OperState, destState.OperStateDownReason.Value, c.wantState, c.wantReason)
+				}
+				a.m.RLock()
+				_, running := a.tunnelClients["t1"]["d1"]
+				a.m.RUnlock()
+				if running != c.wantRunning {
+					t.Errorf("change %d: running = %v, want %v", i+1, running, c.wantRunning)
+				}
+			}
+		})
+	}
+}
+
+func TestSetTargetOperState(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminState string
+		wantState  string
+		wantReason string
+	}{
+		{name: "default", wantState: operUp},
+		{name: "enabled", adminState: adminEnable, wantState: operUp},
+		{name: "disabled", adminState: adminDisable, wantState: operDown, wantReason: "admin down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := new(target)
+			tg.Target.AdminState = tt.adminState
+			tg.Target.OperStateDownReason.Value = "stale"
+			setTargetOperState(tg)
+			if tg.Target.OperState != tt.wantState || tg.Target.OperStateDownReason.Value != tt.wantReason {
+				t.Errorf("oper-state = %s (%q), want %s (%q)",
+					tg.Target.OperState, tg.Target.OperStateDownReason.Value, tt.wantState, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestTargetAdminState(t *testing.T) {
+	const (
+		enabled  = `{"target":{"admin_state":"ADMIN_STATE_enable","type":{"ssh_server":{"value":true}}}}`
+		disabled = `{"target":{"admin_state":"ADMIN_STATE_disable","type":{"ssh_server":{"value":true}}}}`
+	)
+	tests := []struct {
+		name    string
+		configs []string
+		// expected close reason of the target session started before the last change
+		wantReason string
+		wantState  string
+	}{
+		{
+			name:      "enabled",
+			configs:   []string{enabled, enabled},
+			wantState: operUp,
+		},
+		{
+			name:       "disabled",
+			configs:    []string{enabled, disabled},
+			wantReason: closeReasonTargetDisabled,
+			wantState:  operDown,
+		},
+		{
+			name:      "re-enabled",
+			configs:   []string{enabled, disabled, enabled},
+			wantState: operUp,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestAdminStateApp()
+			tdc, _ := startTestTunnel(t)
+			a.tunnelClients["t1"] = map[string]*tunnelDestinationClient{"d1": tdc}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var s *session
+			a.handleTunnelTargetCreate(ctx, "t1", "tg1", testConfigData(tt.configs[0]))
+			for i, cfg := range tt.configs[1:] {
+				// the session is started before the last change
+				if i == len(tt.configs)-2 {
+					s = newTestSession("tg1")
+					a.addSession(s)
+					defer a.endSession(s)
+				}
+				a.handleTunnelTargetChange(ctx, "t1", "tg1", testConfigData(cfg))
+			}
+			// the sessions of the disabled targets are closed in the background
+			deadline := time.Now().Add(5 * time.Second)
+			for tt.wantReason != "" && s.getCloseReason() == "" && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := s.getCloseReason(); got != tt.wantReason {
+				t.Errorf("session close reason = %q, want %q", got, tt.wantReason)
+			}
+			tg := a.config.app.Tunnel["t1"].Tunnel.Target["tg1"]
+			if tg.Target.OperState != tt.wantState {
+				t.Errorf("target oper-state = %s, want %s", tg.Target.OperState, tt.wantState)
+			}
+			registered := tdc.lookupTarget(tunnel.Target{ID: "srl1", Type: targetTypeSSH}) != nil
+			if want := tt.wantState == operUp; registered != want {
+				t.Errorf("target registered = %v, want %v", registered, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDampeningPenalty           = 1000
+	defaultDampeningSuppressThreshold = 2000
+	defaultDampeningReuseThreshold    = 750
+	defaultDampeningHalfLife          = 60 * time.Second
+	defaultDampeningMaxSuppressTime   = 300 * time.Second
+	// interval at which the penalty of a suppressed destination is refreshed in the state
+	dampeningRefreshInterval = 5 * time.Second
+)
+
+type dampeningCfg struct {
+	AdminState        string      `json:"admin_state,omitempty"`
+	Penalty           uint32Value `json:"penalty,omitempty"`
+	SuppressThreshold uint32Value `json:"suppress_threshold,omitempty"`
+	ReuseThreshold    uint32Value `json:"reuse_threshold,omitempty"`
+	HalfLife          uint32Value `json:"half_life,omitempty"`
+	MaxSuppressTime   uint32Value `json:"max_suppress_time,omitempty"`
+}
+
+// dampeningState is the dampening state of a tunnel destination.
+type dampeningState struct {
+	Suppressed boolValue   `json:"suppressed,omitempty"`
+	Penalty    uint32Value `json:"penalty,omitempty"`
+	Flaps      uint64Value `json:"flaps,omitempty"`
+}
+
+// dampeningParams are the dampening parameters of a destination, with the defaults applied.
+type dampeningParams struct {
+	penalty  float64
+	suppress float64
+	reuse    float64
+	halfLife time.Duration
+	// the penalty is capped so that a destination is not suppressed longer than the max suppress time
+	maxPenalty float64
+}
+
+// check returns an error if the destination could never be suppressed, or never reused.
+func (p dampeningParams) check() error {
+	if p.reuse >= p.suppress {
+		return fmt.Errorf("dampening reuse-threshold %.0f must be lower than the suppress-threshold %.0f", p.reuse, p.suppress)
+	}
+	if p.suppress > p.maxPenalty {
+		return fmt.Errorf("dampening suppress-threshold %.0f is above the maximum penalty %.0f allowed by the max-suppress-time, the destination would never be suppressed",
+			p.suppress, p.maxPenalty)
+	}
+	return nil
+}
+
+// newDampeningParams returns the dampening parameters of dest,
+// ok is false if dampening is not enabled for it.
+func newDampeningParams(dest *destination) (dampeningParams, bool) {
+	if dest == nil || dest.Destination.Dampening.AdminState != adminEnable {
+		return dampeningParams{}, false
+	}
+	cfg := dest.Destination.Dampening
+	p := dampeningParams{
+		penalty:  defaultDampeningPenalty,
+		suppress: defaultDampeningSuppressThreshold,
+		reuse:    defaultDampeningReuseThreshold,
+		halfLife: defaultDampeningHalfLife,
+	}
+	if cfg.Penalty.Value > 0 {
+		p.penalty = float64(cfg.Penalty.Value)
+	}
+	if cfg.SuppressThreshold.Value > 0 {
+		p.suppress = float64(cfg.SuppressThreshold.Value)
+	}
+	if cfg.ReuseThreshold.Value > 0 {
+		p.reuse = float64(cfg.ReuseThreshold.Value)
+	}
+	if cfg.HalfLife.Value > 0 {
+		p.halfLife = time.Duration(cfg.HalfLife.Value) * time.Second
+	}
+	maxSuppressTime := defaultDampeningMaxSuppressTime
+	if cfg.MaxSuppressTime.Value > 0 {
+		maxSuppressTime = time.Duration(cfg.MaxSuppressTime.Value) * time.Second
+	}
+	p.maxPenalty = p.reuse * math.Exp2(maxSuppressTime.Seconds()/p.halfLife.Seconds())
+	return p, true
+}
+
+// flapDamper tracks the flaps of a tunnel destination.
+// The targets are not dampened individually, they only flap with their destination.
+// Each flap adds a penalty which decays exponentially with the configured half-life,
+// the destination is suppressed once the penalty reaches the suppress threshold
+// and reused once it decays below the reuse threshold.
+type flapDamper struct {
+	m *sync.Mutex
+	// parameters used for the last flap
+	params     dampeningParams
+	penalty    float64
+	updated    time.Time
+	suppressed bool
+	flaps      uint64
+}
+
+func newFlapDamper() *flapDamper {
+	return &flapDamper{m: new(sync.Mutex)}
+}
+
+// decay updates the penalty to now, must be called with fd.m held.
+func (fd *flapDamper) decay(now time.Time) {
+	if fd.penalty > 0 && fd.params.halfLife > 0 {
+		fd.penalty *= math.Exp2(-now.Sub(fd.updated).Seconds() / fd.params.halfLife.Seconds())
+	}
+	fd.updated = now
+}
+
+// flap records a flap, it returns true if the destination is suppressed.
+func (fd *flapDamper) flap(p dampeningParams, now time.Time) bool {
+	fd.m.Lock()
+	defer fd.m.Unlock()
+	fd.params = p
+	fd.decay(now)
+	fd.penalty = math.Min(fd.penalty+p.penalty, p.maxPenalty)
+	fd.flaps++
+	if fd.penalty >= p.suppress {
+		fd.suppressed = true
+	}
+	return fd.suppressed
+}
+
+// reuseIn returns the time left until a suppressed destination is reused, 0 if it is not suppressed.
+// Disabling dampening reuses the destination.
+func (fd *flapDamper) reuseIn(p dampeningParams, enabled bool, now time.Time) time.Duration {
+	fd.m.Lock()
+	defer fd.m.Unlock()
+	fd.decay(now)
+	if !enabled {
+		fd.penalty = 0
+		fd.suppressed = false
+		return 0
+	}
+	fd.params = p
+	if !fd.suppressed {
+		return 0
+	}
+	if fd.penalty < p.reuse {
+		fd.suppressed = false
+		return 0
+	}
+	d := time.Duration(math.Log2(fd.penalty/p.reuse) * float64(p.halfLife))
+	return max(d, time.Second)
+}
+
+// state returns the dampening state, nil if no flap was recorded.
+func (fd *flapDamper) state(now time.Time) *dampeningState {
+	fd.m.Lock()
+	defer fd.m.Unlock()
+	if fd.flaps == 0 {
+		return nil
+	}
+	fd.decay(now)
+	return &dampeningState{
+		Suppressed: boolValue{Value: fd.suppressed},
+		Penalty:    uint32Value{Value: uint32(math.Round(fd.penalty))},
+		Flaps:      uint64Value{Value: fd.flaps},
+	}
+}
+
+// destinationFlapped records a flap of destination dn of tunnel tn,
+// i.e. the loss of an established connection.
+func (a *app) destinationFlapped(tn, dn string, destState *destinationState) {
+	a.config.m.Lock()
+	p, ok := newDampeningParams(a.config.app.Destination[dn])
+	a.config.m.Unlock()
+	if !ok {
+		return
+	}
+	if err := p.check(); err != nil {
+		a.tunnelLog(tn).WithField("destination", dn).Errorf("flap not dampened: %v", err)
+		return
+	}
+	if destState.damper.flap(p, time.Now()) {
+		a.tunnelLog(tn).WithField("destination", dn).Warn("destination suppressed by dampening")
+	}
+}
+
+// waitDampening holds destination dn of tunnel tn down while it is suppressed,
+// refreshing its penalty in the state.
+// It returns false if ctx is done before the destination is reused.
+func (a *app) waitDampening(ctx context.Context, tn, dn string, destState *destinationState) bool {
+	suppressed := false
+	for {
+		a.config.m.Lock()
+		p, ok := newDampeningParams(a.config.app.Destination[dn])
+		a.config.m.Unlock()
+		// an invalid config disables dampening
+		d := destState.damper.reuseIn(p, ok && p.check() == nil, time.Now())
+		if d == 0 {
+			if suppressed {
+				a.tunnelLog(tn).WithField("destination", dn).Info("destination reused")
+			}
+			return true
+		}
+		suppressed = true
+		destState.OperState = operDown
+		destState.OperStateDownReason.Value = "suppressed by dampening"
+		a.updateTunnelDestinationTelemetry(tn, dn, destState)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(min(d, dampeningRefreshInterval)):
+		}
+	}
+}
+
+// setDestinationTargetsDown sets the oper-state of the targets registered to destination dn of tunnel tn to down.
+func (a *app) setDestinationTargetsDown(tn, dn string, destState *destinationState, reason string) {
+	for name, ts := range destState.Target {
+		// target states are keyed by "<id>:::<type>"
+		id, typ, ok := strings.Cut(name, ":::")
+		if !ok {
+			log.Errorf("unexpected target name %q", name)
+			continue
+		}
+		ts.Target.OperState = operDown
+		ts.Target.OperStateDownReason.Value = reason
+		a.updateTunnelDestinationTargetTelemetry(tn, dn, id, typ, ts)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+// newTestDampeningParams returns the dampening parameters of a destination dampening config, in NDK JSON.
+func newTestDampeningParams(t *testing.T, cfg string) (dampeningParams, bool) {
+	t.Helper()
+	dest := new(destination)
+	if err := json.Unmarshal([]byte(`{"destination":{"dampening":`+cfg+`}}`), dest); err != nil {
+		t.Fatalf("failed to decode dampening config %s: %v", cfg, err)
+	}
+	return newDampeningParams(dest)
+}
+
+func TestNewDampeningParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     string
+		wantOK  bool
+		want    dampeningParams
+		wantErr bool
+	}{
+		{
+			name: "disabled",
+			cfg:  `{}`,
+		},
+		{
+			name:   "defaults",
+			cfg:    `{"admin_state":"ADMIN_STATE_enable"}`,
+			wantOK: true,
+			want: dampeningParams{
+				penalty:    defaultDampeningPenalty,
+				suppress:   defaultDampeningSuppressThreshold,
+				reuse:      defaultDampeningReuseThreshold,
+				halfLife:   defaultDampeningHalfLife,
+				maxPenalty: defaultDampeningReuseThreshold * 32,
+			},
+		},
+		{
+			name:   "configured",
+			cfg:    `{"admin_state":"ADMIN_STATE_enable","penalty":{"value":500},"suppress_threshold":{"value":1500},"reuse_threshold":{"value":400},"half_life":{"value":30},"max_suppress_time":{"value":60}}`,
+			wantOK: true,
+			want: dampeningParams{
+				penalty:    500,
+				suppress:   1500,
+				reuse:      400,
+				halfLife:   30 * time.Second,
+				maxPenalty: 1600,
+			},
+		},
+		{
+			name:    "reuse above suppress",
+			cfg:     `{"admin_state":"ADMIN_STATE_enable","reuse_threshold":{"value":3000}}`,
+			wantOK:  true,
+			wantErr: true,
+		},
+		{
+			name:    "suppress above the max penalty",
+			cfg:     `{"admin_state":"ADMIN_STATE_enable","half_life":{"value":300},"max_suppress_time":{"value":60}}`,
+			wantOK:  true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := newTestDampeningParams(t, tt.cfg)
+			if ok != tt.wantOK {
+				t.Fatalf("newDampeningParams() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if err := got.check(); (err != nil) != tt.wantErr {
+				t.Fatalf("check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if math.Abs(got.maxPenalty-tt.want.maxPenalty) > 1e-6 {
+				t.Errorf("max penalty = %g, want %g", got.maxPenalty, tt.want.maxPenalty)
+			}
+			got.maxPenalty = tt.want.maxPenalty
+			if got != tt.want {
+				t.Errorf("newDampeningParams() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlapDamper(t *testing.T) {
+	p := dampeningParams{
+		penalty:    1000,
+		suppress:   2000,
+		reuse:      750,
+		halfLife:   time.Minute,
+		maxPenalty: 750 * 32,
+	}
+	// flap at the given offset, or reuse check if flap is false
+	type step struct {
+		at             time.Duration
+		flap           bool
+		enabled        bool
+		wantSuppressed bool
+		// expected reuse delay, checked for reuse steps
+		wantReuseIn time.Duration
+	}
+	tests := []struct {
+		name   string
+		params dampeningParams
+		steps  []step
+	}{
+		{
+			name:   "single flap",
+			params: p,
+			steps: []step{
+				{at: 0, flap: true},
+				{at: time.Second, enabled: true},
+			},
+		},
+		{
+			name:   "suppressed after two flaps",
+			params: p,
+			steps: []step{
+				{at: 0, flap: true},
+				{at: 0, flap: true, wantSuppressed: true},
+				// 2000 decays to 750 after log2(2000/750) half-lives
+				{at: 0, enabled: true, wantSuppressed: true, wantReuseIn: time.Duration(math.Log2(2000.0/750) * float64(time.Minute))},
+				{at: time.Minute, enabled: true, wantSuppressed: true, wantReuseIn: time.Duration(math.Log2(1000.0/750) * float64(time.Minute))},
+				{at: 2 * time.Minute, enabled: true},
+			},
+		},
+		{
+			name:   "decayed flaps",
+			params: p,
+			steps: []step{
+				{at: 0, flap: true},
+				{at: 2 * time.Minute, flap: true},
+				{at: 2 * time.Minute, enabled: true},
+			},
+		},
+		{
+			name:   "disabling reuses",
+			params: p,
+			steps: []step{
+				{at: 0, flap: true},
+				{at: 0, flap: true, wantSuppressed: true},
+				{at: time.Second, enabled: false},
+				{at: time.Second, flap: true},
+			},
+		},
+		{
+			name:   "penalty capped to the max suppress time",
+			params: dampeningParams{penalty: 1000, suppress: 2000, reuse: 750, halfLife: time.Minute, maxPenalty: 3000},
+			steps: []step{
+				{at: 0, flap: true},
+				{at: 0, flap: true, wantSuppressed: true},
+				{at: 0, flap: true, wantSuppressed: true},
+				{at: 0, flap: true, wantSuppressed: true},
+				// capped at 3000: reused after 2 half-lives, not 3+
+				{at: 0, enabled: true, wantSuppressed: true, wantReuseIn: 2 * time.Minute},
+			},
+		},
+		{
+			name:   "minimum reuse delay",
+			params: p,
+			steps: []step{
+				{at: 0, flap: true},
+				{at: 0, flap: true, wantSuppressed: true},
+				{at: time.Duration(math.Log2(2000.0/750)*float64(time.Minute)) - time.Millisecond, enabled: true, wantSuppressed: true, wantReuseIn: time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fd := newFlapDamper()
+			start := time.Now()
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				if s.flap {
+					if got := fd.flap(tt.params, now); got != s.wantSuppressed {
+						t.Fatalf("step %d: flap() = %v, want %v", i+1, got, s.wantSuppressed)
+					}
+					continue
+				}
+				got := fd.reuseIn(tt.params, s.enabled, now)
+				if d := got - s.wantReuseIn; d > time.Millisecond || d < -time.Millisecond {
+					t.Fatalf("step %d: reuseIn() = %s, want %s", i+1, got, s.wantReuseIn)
+				}
+				if fd.suppressed != s.wantSuppressed {
+					t.Fatalf("step %d: suppressed = %v, want %v", i+1, fd.suppressed, s.wantSuppressed)
+				}
+			}
+			st := fd.state(start.Add(tt.steps[len(tt.steps)-1].at))
+			if st == nil || st.Suppressed.Value != fd.suppressed {
+				t.Errorf("state() = %+v, want suppressed %v", st, fd.suppressed)
+			}
+		})
+	}
+}
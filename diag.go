@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/grpctunnel/tunnel"
+	"github.com/vishvananda/netns"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	tpb "github.com/openconfig/grpctunnel/proto/tunnel"
+)
+
+const diagSteps = 5
+
+// runDiag implements the diag subcommand:
+// diag -destination <name> [-file <file>] [-timeout <duration>] [-username <user>] [-password <password>]
+func runDiag(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("diag", flag.ContinueOnError)
+	dn := fs.String("destination", "", "name of the destination to diagnose")
+	file := fs.String("file", "", "read the destination from a JSON/YAML configuration file instead of the running configuration")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of each step")
+	username := fs.String("username", "", "username sent to the local gNMI server to read the running configuration")
+	password := fs.String("password", "", "password sent to the local gNMI server to read the running configuration")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dn == "" {
+		return fmt.Errorf("usage: diag -destination <name> [-file <file>] [-timeout <duration>] [-username <user>] [-password <password>]")
+	}
+	ctx := context.Background()
+	dest, err := loadDestination(withGnmiCredentials(ctx, *username, *password), *dn, *file, *timeout)
+	if err != nil {
+		return err
+	}
+	d := &diag{w: w, timeout: *timeout}
+	return d.run(ctx, *dn, dest)
+}
+
+// loadDestination returns the config of destination dn,
+// from file if set, from the running configuration otherwise.
+// The running configuration is read with the gNMI credentials carried by ctx.
+func loadDestination(ctx context.Context, dn, file string, timeout time.Duration) (*destination, error) {
+	if file != "" {
+		var blob any
+		err := readYAMLorJSON(file, &blob)
+		if err != nil {
+			return nil, err
+		}
+		appCfg, err := parseGrpcTunnelBlob(blob)
+		if err != nil {
+			return nil, err
+		}
+		dest, ok := appCfg.Destination[dn]
+		if !ok {
+			return nil, fmt.Errorf("destination %s not found in %s", dn, file)
+		}
+		return dest, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, gnmiServerUnixSocket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", gnmiServerUnixSocket, err)
+	}
+	defer conn.Close()
+	rsp, err := gnmi.NewGNMIClient(conn).Get(ctx,
+		&gnmi.GetRequest{
+			Path: []*gnmi.Path{{
+				Elem: []*gnmi.PathElem{
+					{Name: "system"},
+					{Name: "grpc-tunnel"},
+					{Name: "destination", Key: map[string]string{"name": dn}},
+				},
+			}},
+			Type:     gnmi.GetRequest_CONFIG,
+			Encoding: gnmi.Encoding_JSON_IETF,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get destination %s config: %v", dn, err)
+	}
+	for _, n := range rsp.GetNotification() {
+		for _, u := range n.GetUpdate() {
+			entry := make(map[string]any)
+			err = json.Unmarshal(u.GetVal().GetJsonIetfVal(), &entry)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode destination %s config: %v", dn, err)
+			}
+			// some servers return the entry wrapped in its list
+			for k, v := range entry {
+				if k == "destination" || strings.HasSuffix(k, ":destination") {
+					if entries := listEntries(v); len(entries) == 1 {
+						entry = entries[0]
+					}
+				}
+			}
+			dest := new(destination)
+			err = decodeNDK("destination", entry, dest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode destination %s config: %v", dn, err)
+			}
+			return dest, nil
+		}
+	}
+	return nil, fmt.Errorf("destination %s not found", dn)
+}
+
+// diag runs the connectivity checks towards a destination
+// and writes a step by step report.
+type diag struct {
+	w       io.Writer
+	timeout time.Duration
+	step    int
+}
+
+func (d *diag) start(format string, args ...any) {
+	d.step++
+	fmt.Fprintf(d.w, "[%d/%d] %s: ", d.step, diagSteps, fmt.Sprintf(format, args...))
+}
+
+func (d *diag) ok(start time.Time, format string, args ...any) {
+	fmt.Fprintf(d.w, "OK (%s) %s\n", time.Since(start).Round(time.Microsecond), fmt.Sprintf(format, args...))
+}
+
+func (d *diag) fail(err error) error {
+	fmt.Fprintf(d.w, "FAILED: %v\n", err)
+	return fmt.Errorf("step %d failed", d.step)
+}
+
+func (d *diag) detail(format string, args ...any) {
+	fmt.Fprintf(d.w, "      %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *diag) run(ctx context.Context, dn string, dest *destination) error {
+	cfg := dest.Destination
+	port := cfg.Port.Value
+	if port == "" {
+		port = "57401"
+	}
+	netIns := cfg.NetworkInstance.Value
+	if netIns == "" {
+		netIns = "mgmt"
+	}
+	netInsName := fmt.Sprintf("srbase-%s", netIns)
+
+	// step 1: enter the network-instance namespace
+	d.start("destination %s: network-instance %s", dn, netIns)
+	now := time.Now()
+	n, err := netns.GetFromName(netInsName)
+	if err != nil {
+		return d.fail(fmt.Errorf("failed getting namespace %q: %v", netInsName, err))
+	}
+	defer n.Close()
+	// the steps below run in the destination namespace, on this OS thread
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	orig, err := netns.Get()
+	if err != nil {
+		return d.fail(fmt.Errorf("failed getting the current namespace: %v", err))
+	}
+	defer func() {
+		netns.Set(orig)
+		orig.Close()
+	}()
+	if err = netns.Set(n); err != nil {
+		return d.fail(fmt.Errorf("failed setting namespace %q: %v", netInsName, err))
+	}
+	d.ok(now, "namespace %s", netInsName)
+
+	// step 2: resolve the address
+	d.start("resolve %s", cfg.Address.Value)
+	now = time.Now()
+	rctx, cancel := context.WithTimeout(ctx, d.timeout)
+	addrs, err := net.DefaultResolver.LookupHost(rctx, cfg.Address.Value)
+	cancel()
+	if err != nil {
+		return d.fail(err)
+	}
+	d.ok(now, "%s", strings.Join(addrs, ", "))
+
+	// step 3: TCP connect
+	addr := net.JoinHostPort(addrs[0], port)
+	d.start("tcp connect %s", addr)
+	now = time.Now()
+	dialer, err := newSocketDialer(cfg.Socket)
+	if err != nil {
+		return d.fail(err)
+	}
+	dialer.Timeout = d.timeout
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return d.fail(err)
+	}
+	d.ok(now, "local address %s", conn.LocalAddr())
+
+	// step 4: TLS handshake
+	d.start("tls handshake")
+	if cfg.NoTLS.Value {
+		conn.Close()
+		fmt.Fprintln(d.w, "SKIPPED: no-tls is set")
+	} else {
+		now = time.Now()
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName:         cfg.Address.Value,
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2"},
+		})
+		tlsConn.SetDeadline(time.Now().Add(d.timeout))
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return d.fail(err)
+		}
+		cs := tlsConn.ConnectionState()
+		tlsConn.Close()
+		d.ok(now, "%s, %s, alpn=%q", tls.VersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite), cs.NegotiatedProtocol)
+		d.reportCertificates(cfg.Address.Value, cs.PeerCertificates)
+	}
+
+	// step 5: tunnel register, using the same dial options as the agent
+	d.start("tunnel register")
+	now = time.Now()
+	opts := []grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			runtime.LockOSThread()
+			if err := netns.Set(n); err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, "tcp", addr)
+		}),
+	}
+	if cfg.NoTLS.Value {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})))
+	}
+	opts = append(opts, transportDialOptions(dest)...)
+	rctx, cancel = context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	gconn, err := grpc.DialContext(rctx, net.JoinHostPort(cfg.Address.Value, port), opts...)
+	if err != nil {
+		return d.fail(fmt.Errorf("gRPC dial: %v", err))
+	}
+	defer gconn.Close()
+	client, err := tunnel.NewClient(tpb.NewTunnelClient(gconn), tunnel.ClientConfig{
+		RegisterHandler: func(t tunnel.Target) error { return nil },
+		Handler:         func(t tunnel.Target, i io.ReadWriteCloser) error { return i.Close() },
+	}, nil)
+	if err != nil {
+		return d.fail(err)
+	}
+	err = client.Register(rctx)
+	if err != nil {
+		return d.fail(err)
+	}
+	d.ok(now, "registered with %s", gconn.Target())
+	fmt.Fprintln(d.w, "destination is reachable")
+	return nil
+}
+
+// reportCertificates writes the server certificate chain
+// and its verification result against the system roots.
+func (d *diag) reportCertificates(serverName string, certs []*x509.Certificate) {
+	for i, c := range certs {
+		d.detail("certificate %d: subject=%q, issuer=%q", i, c.Subject.String(), c.Issuer.String())
+		d.detail("  valid from %s to %s", c.NotBefore.Format(time.RFC3339), c.NotAfter.Format(time.RFC3339))
+		if len(c.DNSNames) > 0 || len(c.IPAddresses) > 0 {
+			sans := append([]string{}, c.DNSNames...)
+			for _, ip := range c.IPAddresses {
+				sans = append(sans, ip.String())
+			}
+			d.detail("  SANs: %s", strings.Join(sans, ", "))
+		}
+	}
+	if len(certs) == 0 {
+		return
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		d.detail("verification against the system roots failed: %v (the agent does not verify the server certificate)", err)
+		return
+	}
+	d.detail("verification against the system roots succeeded")
+}
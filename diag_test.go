@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunDiagArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{
+			name:    "no destination",
+			args:    []string{"-timeout", "1s"},
+			wantErr: "usage: diag -destination <name>",
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-destination", "d1", "-tls"},
+			wantErr: "flag provided but not defined: -tls",
+		},
+		{
+			name:    "missing file",
+			args:    []string{"-destination", "d1", "-file", filepath.Join(t.TempDir(), "missing.json")},
+			wantErr: "no such file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := new(bytes.Buffer)
+			err := runDiag(tt.args, w)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("runDiag() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadDestinationFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"destination":[{"name":"d1","address":"172.20.20.2","port":57402,"no-tls":true}]}`,
+		"config.yaml": "destination:\n  - name: d1\n    address: 172.20.20.2\n    port: 57402\n    no-tls: true\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		file    string
+		dn      string
+		wantErr bool
+	}{
+		{name: "json", file: "config.json", dn: "d1"},
+		{name: "yaml", file: "config.yaml", dn: "d1"},
+		{name: "unknown destination", file: "config.json", dn: "d2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest, err := loadDestination(context.Background(), tt.dn, filepath.Join(dir, tt.file), time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadDestination() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if d := dest.Destination; d.Address.Value != "172.20.20.2" || d.Port.Value != "57402" || !d.NoTLS.Value {
+				t.Errorf("destination = %+v", d)
+			}
+		})
+	}
+}
+
+func TestDiagReport(t *testing.T) {
+	w := new(bytes.Buffer)
+	d := &diag{w: w, timeout: time.Second}
+	d.start("resolve %s", "tunnel.example.com")
+	d.ok(time.Now(), "%s", "172.20.20.2")
+	d.start("tcp connect %s", "172.20.20.2:57401")
+	err := d.fail(errors.New("connection refused"))
+	if err == nil || err.Error() != "step 2 failed" {
+		t.Errorf("fail() = %v, want step 2 failed", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("report = %q, want 2 lines", w.String())
+	}
+	if !strings.HasPrefix(lines[0], "[1/5] resolve tunnel.example.com: OK (") || !strings.HasSuffix(lines[0], ") 172.20.20.2") {
+		t.Errorf("line 1 = %q", lines[0])
+	}
+	if want := "[2/5] tcp connect 172.20.20.2:57401: FAILED: connection refused"; lines[1] != want {
+		t.Errorf("line 2 = %q, want %q", lines[1], want)
+	}
+}
+
+func TestReportCertificates(t *testing.T) {
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+	tests := []struct {
+		name  string
+		certs []*x509.Certificate
+		want  []string
+	}{
+		{
+			name: "no certificate",
+		},
+		{
+			name:  "self signed",
+			certs: []*x509.Certificate{srv.Certificate()},
+			want: []string{
+				`      certificate 0: subject="O=Acme Co", issuer="O=Acme Co"`,
+				"        valid from ",
+				"        SANs: example.com, *.example.com, 127.0.0.1, ::1",
+				"      verification against the system roots failed: ",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := new(bytes.Buffer)
+			d := &diag{w: w}
+			d.reportCertificates("127.0.0.1", tt.certs)
+			if len(tt.want) == 0 {
+				if w.Len() != 0 {
+					t.Errorf("report = %q, want none", w.String())
+				}
+				return
+			}
+			lines := strings.Split(strings.TrimSuffix(w.String(), "\n"), "\n")
+			if len(lines) != len(tt.want) {
+				t.Fatalf("report = %q, want %d lines", w.String(), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Errorf("line %d = %q, want prefix %q", i+1, lines[i], want)
+				}
+			}
+		})
+	}
+}
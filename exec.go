@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultExecPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// execConfig describes the process spawned for each session
+// towards an exec target.
+type execConfig struct {
+	command string
+	args    []string
+	user    string
+	env     []string
+	dir     string
+	timeout time.Duration
+}
+
+// newExecConfig returns the exec configuration of a target,
+// nil if the target does not have an exec command configured.
+func newExecConfig(tg *target) *execConfig {
+	if tg.Target.Exec.Command.Value == "" {
+		return nil
+	}
+	ec := &execConfig{
+		command: tg.Target.Exec.Command.Value,
+		args:    make([]string, 0, len(tg.Target.Exec.Args)),
+		user:    tg.Target.Exec.User.Value,
+		env:     make([]string, 0, len(tg.Target.Exec.Environment)+1),
+		dir:     tg.Target.Exec.WorkingDirectory.Value,
+		timeout: time.Duration(tg.Target.Exec.Timeout.Value) * time.Second,
+	}
+	for _, arg := range tg.Target.Exec.Args {
+		ec.args = append(ec.args, arg.Value)
+	}
+	ec.env = append(ec.env, defaultExecPath)
+	for _, env := range tg.Target.Exec.Environment {
+		ec.env = append(ec.env, env.Value)
+	}
+	return ec
+}
+
+// execSession spawns the configured command and bridges the tunnel session
+// to its stdin/stdout, the command stderr is sent to the agent log.
+// The session is closed when the command exits, the command is killed if ctx is canceled.
+// The errors only end the session and are logged on slog: returned to the tunnel
+// handler, they would tear down the tunnel client and all its sessions.
+func execSession(ctx context.Context, slog *log.Entry, ec *execConfig, rwc io.ReadWriteCloser) {
+	defer rwc.Close()
+	if ec.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ec.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, ec.command, ec.args...)
+	cmd.Env = ec.env
+	cmd.Dir = ec.dir
+	if ec.user != "" {
+		cred, err := userCredential(ec.user)
+		if err != nil {
+			slog.Errorf("failed to run command %q: %v", ec.command, err)
+			return
+		}
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		slog.Errorf("failed to create stdin pipe: %v", err)
+		return
+	}
+	cmd.Stdout = rwc
+	stderr := slog.WriterLevel(log.WarnLevel)
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	slog.Infof("running command %q", cmd.String())
+	err = cmd.Start()
+	if err != nil {
+		slog.Errorf("failed to start command %q: %v", ec.command, err)
+		return
+	}
+	go func() {
+		io.Copy(stdin, rwc)
+		stdin.Close()
+	}()
+	err = cmd.Wait()
+	exitErr := new(exec.ExitError)
+	switch {
+	case errors.As(err, &exitErr):
+		slog.Infof("command %q exited: %v", ec.command, err)
+	case err != nil:
+		slog.Errorf("command %q failed: %v", ec.command, err)
+	}
+}
+
+// userCredential looks up a local user and returns the credential
+// used to run a command as that user.
+func userCredential(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lookup user %q: %v", name, err)
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q for user %q: %v", u.Uid, name, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q for user %q: %v", u.Gid, name, err)
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	gids, err := u.GroupIds()
+	if err != nil {
+		return cred, nil
+	}
+	for _, g := range gids {
+		gid, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			continue
+		}
+		cred.Groups = append(cred.Groups, uint32(gid))
+	}
+	return cred, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestNewExecConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   *execConfig
+	}{
+		{
+			name:   "no command",
+			target: `{"target":{"exec":{"args":[{"value":"-l"}]}}}`,
+		},
+		{
+			name:   "command",
+			target: `{"target":{"exec":{"command":{"value":"/bin/bash"}}}}`,
+			want: &execConfig{
+				command: "/bin/bash",
+				args:    []string{},
+				env:     []string{defaultExecPath},
+			},
+		},
+		{
+			name:   "all options",
+			target: `{"target":{"exec":{"command":{"value":"/usr/bin/python3"},"args":[{"value":"-u"},{"value":"script.py"}],"user":{"value":"admin"},"environment":[{"value":"LANG=C"}],"working_directory":{"value":"/tmp"},"timeout":{"value":30}}}}`,
+			want: &execConfig{
+				command: "/usr/bin/python3",
+				args:    []string{"-u", "script.py"},
+				user:    "admin",
+				env:     []string{defaultExecPath, "LANG=C"},
+				dir:     "/tmp",
+				timeout: 30 * time.Second,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newExecConfig(newTestTarget(t, tt.target))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newExecConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecSession(t *testing.T) {
+	tests := []struct {
+		name string
+		ec   *execConfig
+		// written by the remote end, which then closes the session
+		input string
+		want  string
+		// expected log message, at the expected level
+		wantLog   string
+		wantLevel log.Level
+	}{
+		{
+			name:      "round trip",
+			ec:        &execConfig{command: "/bin/cat", env: []string{defaultExecPath}},
+			input:     "hello",
+			want:      "hello",
+			wantLog:   `running command "/bin/cat"`,
+			wantLevel: log.InfoLevel,
+		},
+		{
+			name:      "non zero exit",
+			ec:        &execConfig{command: "/bin/sh", args: []string{"-c", "echo -n bye; exit 3"}, env: []string{defaultExecPath}},
+			want:      "bye",
+			wantLog:   `command "/bin/sh" exited: exit status 3`,
+			wantLevel: log.InfoLevel,
+		},
+		{
+			name:      "missing command",
+			ec:        &execConfig{command: "/nonexistent/command"},
+			wantLog:   `failed to start command "/nonexistent/command"`,
+			wantLevel: log.ErrorLevel,
+		},
+		{
+			name:      "unknown user",
+			ec:        &execConfig{command: "/bin/cat", user: "nonexistent-user"},
+			wantLog:   `failed to lookup user "nonexistent-user"`,
+			wantLevel: log.ErrorLevel,
+		},
+		{
+			name:      "timeout",
+			ec:        &execConfig{command: "/bin/sleep", args: []string{"10"}, timeout: 100 * time.Millisecond},
+			wantLog:   `command "/bin/sleep" exited: signal: killed`,
+			wantLevel: log.InfoLevel,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := logtest.NewNullLogger()
+			local, remote := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				execSession(context.Background(), log.NewEntry(logger), tt.ec, local)
+			}()
+			remote.SetDeadline(time.Now().Add(5 * time.Second))
+			if tt.input != "" {
+				if _, err := remote.Write([]byte(tt.input)); err != nil {
+					t.Fatalf("failed to write input: %v", err)
+				}
+			}
+			got := make([]byte, len(tt.want))
+			if _, err := io.ReadFull(remote, got); err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+			if tt.input != "" {
+				remote.Close()
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("execSession() did not return")
+			}
+			// the session is closed
+			if _, err := remote.Read(make([]byte, 1)); err == nil {
+				t.Error("session not closed")
+			}
+			found := false
+			for _, e := range hook.AllEntries() {
+				if strings.Contains(e.Message, tt.wantLog) && e.Level == tt.wantLevel {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("log %q at level %s not found in %d entries", tt.wantLog, tt.wantLevel, len(hook.AllEntries()))
+			}
+		})
+	}
+}
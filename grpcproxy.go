@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openconfig/grpctunnel/tunnel"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcProxyConfig describes how the gRPC calls received
+// through a tunnel session are proxied to the local gRPC server.
+type grpcProxyConfig struct {
+	username string
+	password string
+	localTLS bool
+	policy   *rpcPolicy
+}
+
+// newGrpcProxyConfig returns the gRPC proxy configuration of a target,
+// nil if the target sessions are raw byte pipes.
+// An RPC policy implies a gRPC proxy, with or without credentials injection.
+func newGrpcProxyConfig(tg *target) *grpcProxyConfig {
+	policy := newRPCPolicy(tg)
+	if tg.Target.GrpcProxy.AdminState != adminEnable && policy == nil {
+		return nil
+	}
+	pc := &grpcProxyConfig{
+		localTLS: tg.Target.GrpcProxy.LocalTLS.Value,
+		policy:   policy,
+	}
+	if tg.Target.GrpcProxy.AdminState == adminEnable {
+		pc.username = tg.Target.GrpcProxy.Username.Value
+		pc.password = tg.Target.GrpcProxy.Password.Value
+	}
+	return pc
+}
+
+// proxySession terminates the gRPC connection carried by a tunnel session
+// and proxies each RPC to the gRPC server listening on the target dial address.
+func (a *app) proxySession(sess *session, ttd *tunnelTargetDetails, rwc io.ReadWriteCloser) error {
+	dialAddr := ttd.dialAddress
+	pc := ttd.proxy
+	opts := []grpc.DialOption{
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})),
+	}
+	if pc.localTLS {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if ttd.dscp > 0 && !strings.HasPrefix(dialAddr, "unix") {
+		dialer := &net.Dialer{Control: socketControl(ttd.dscp, 0, "")}
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}))
+	}
+	conn, err := grpc.Dial(dialAddr, opts...)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %v", dialAddr, err)
+	}
+	defer conn.Close()
+
+	p := &grpcProxy{
+		target: sess.tt,
+		log:    sess.log,
+		pc:     pc,
+		conn:   conn,
+		denied: func(method, reason string) {
+			a.rpcDenied(sess.tunnel, ttd.name, method, reason)
+		},
+	}
+	s := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(p.handler),
+	)
+	defer s.Stop()
+	sess.log.Infof("proxying gRPC session for target %+v to %s", sess.tt, dialAddr)
+	err = s.Serve(newSessionListener(rwc))
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("gRPC proxy error: %v", err)
+	}
+	return nil
+}
+
+type grpcProxy struct {
+	target tunnel.Target
+	log    *log.Entry
+	pc     *grpcProxyConfig
+	conn   *grpc.ClientConn
+	// called when an RPC is denied by the policy
+	denied func(method, reason string)
+}
+
+// handler checks an RPC against the target policy and forwards it to the local server,
+// replacing the credentials sent by the remote client with the configured ones.
+func (p *grpcProxy) handler(_ any, ss grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(ss)
+	if !ok {
+		return status.Error(codes.Internal, "failed to get method name")
+	}
+	p.log.Debugf("proxying RPC %s", method)
+	policy := p.pc.policy
+	if policy != nil && !policy.allowMethod(method) {
+		p.denied(method, "method not allowed")
+		return status.Errorf(codes.PermissionDenied, "method %s is not allowed", method)
+	}
+	inspect := policy != nil && policy.inspects(method)
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	md = md.Copy()
+	if p.pc.username != "" {
+		md.Set("username", p.pc.username)
+		md.Set("password", p.pc.password)
+	}
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(ss.Context(), md))
+	defer cancel()
+	cs, err := p.conn.NewStream(ctx,
+		&grpc.StreamDesc{ServerStreams: true, ClientStreams: true},
+		method)
+	if err != nil {
+		return err
+	}
+	errCh := make(chan error, 2)
+	// remote client to local server
+	go func() {
+		for {
+			f := new(frame)
+			if err := ss.RecvMsg(f); err != nil {
+				if errors.Is(err, io.EOF) {
+					cs.CloseSend()
+					return
+				}
+				errCh <- err
+				return
+			}
+			if inspect {
+				if xp, ok := policy.allowMessage(method, f.payload); !ok {
+					p.denied(method, fmt.Sprintf("path %q not allowed", xp))
+					errCh <- status.Errorf(codes.PermissionDenied, "path %q is not allowed", xp)
+					return
+				}
+			}
+			if err := cs.SendMsg(f); err != nil {
+				// the error is returned by the local server stream RecvMsg
+				return
+			}
+		}
+	}()
+	// local server to remote client
+	go func() {
+		md, err := cs.Header()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		if err = ss.SendHeader(md); err != nil {
+			errCh <- err
+			return
+		}
+		for {
+			f := new(frame)
+			if err := cs.RecvMsg(f); err != nil {
+				errCh <- err
+				return
+			}
+			if err := ss.SendMsg(f); err != nil {
+				errCh <- err
+				return
+			}
+		}
+	}()
+	err = <-errCh
+	ss.SetTrailer(cs.Trailer())
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// frame is an undecoded gRPC message.
+type frame struct {
+	payload []byte
+}
+
+// rawCodec passes gRPC messages through without decoding them.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	f, ok := v.(*frame)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return f.payload, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	f, ok := v.(*frame)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	f.payload = append(f.payload[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
+
+// sessionListener is a net.Listener that accepts a single tunnel session,
+// it is closed when the session is.
+type sessionListener struct {
+	conn   *sessionConn
+	accept chan net.Conn
+}
+
+func newSessionListener(rwc io.ReadWriteCloser) *sessionListener {
+	l := &sessionListener{
+		conn:   &sessionConn{ReadWriteCloser: rwc, closed: make(chan struct{})},
+		accept: make(chan net.Conn, 1),
+	}
+	l.accept <- l.conn
+	return l
+}
+
+func (l *sessionListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.conn.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *sessionListener) Close() error {
+	return l.conn.Close()
+}
+
+func (l *sessionListener) Addr() net.Addr { return sessionAddr{} }
+
+// sessionConn wraps a tunnel session as a net.Conn.
+type sessionConn struct {
+	io.ReadWriteCloser
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *sessionConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.ReadWriteCloser.Close()
+		close(c.closed)
+	})
+	return err
+}
+
+func (c *sessionConn) LocalAddr() net.Addr                { return sessionAddr{} }
+func (c *sessionConn) RemoteAddr() net.Addr               { return sessionAddr{} }
+func (c *sessionConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sessionConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sessionConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type sessionAddr struct{}
+
+func (sessionAddr) Network() string { return "tunnel" }
+func (sessionAddr) String() string  { return "tunnel" }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/grpctunnel/tunnel"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewGrpcProxyConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   *grpcProxyConfig
+	}{
+		{
+			name:   "no proxy",
+			target: `{"target":{}}`,
+		},
+		{
+			name:   "disabled proxy",
+			target: `{"target":{"grpc_proxy":{"admin_state":"ADMIN_STATE_disable","username":{"value":"admin"}}}}`,
+		},
+		{
+			name:   "credentials",
+			target: `{"target":{"grpc_proxy":{"admin_state":"ADMIN_STATE_enable","username":{"value":"admin"},"password":{"value":"secret"},"local_tls":{"value":true}}}}`,
+			want:   &grpcProxyConfig{username: "admin", password: "secret", localTLS: true},
+		},
+		{
+			name:   "policy without credentials",
+			target: `{"target":{"grpc_proxy":{"admin_state":"ADMIN_STATE_disable","username":{"value":"admin"}},"rpc_policy":{"deny_method":[{"value":"/gnmi.gNMI/Set"}]}}}`,
+			want: &grpcProxyConfig{policy: &rpcPolicy{
+				allowMethods: []string{},
+				denyMethods:  []string{"/gnmi.gNMI/Set"},
+				pathPrefixes: []*gnmi.Path{},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newGrpcProxyConfig(newTestTarget(t, tt.target))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newGrpcProxyConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// serveTestGrpc serves the gRPC unknown service handler h on a local TCP port, it returns its address.
+func serveTestGrpc(t *testing.T, h grpc.StreamHandler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(h))
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return l.Addr().String()
+}
+
+func TestGrpcProxyHandler(t *testing.T) {
+	// the local server echoes the requests, along with the method and username it received
+	local := serveTestGrpc(t, func(_ any, ss grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(ss)
+		if method == "/test.Echo/Fail" {
+			return status.Error(codes.NotFound, "not found")
+		}
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		ss.SetHeader(metadata.MD{"method": {method}, "username": md.Get("username")})
+		f := new(frame)
+		if err := ss.RecvMsg(f); err != nil {
+			return err
+		}
+		return ss.SendMsg(f)
+	})
+	tests := []struct {
+		name     string
+		pc       *grpcProxyConfig
+		method   string
+		username string
+		wantCode codes.Code
+		// expected username received by the local server
+		wantUsername string
+		wantDenied   bool
+	}{
+		{
+			name:         "forwarded",
+			pc:           new(grpcProxyConfig),
+			method:       "/test.Echo/Echo",
+			username:     "remote",
+			wantUsername: "remote",
+		},
+		{
+			name:         "credentials replaced",
+			pc:           &grpcProxyConfig{username: "admin", password: "secret"},
+			method:       "/test.Echo/Echo",
+			username:     "remote",
+			wantUsername: "admin",
+		},
+		{
+			name:         "allowed method",
+			pc:           &grpcProxyConfig{policy: &rpcPolicy{allowMethods: []string{"/test.Echo/*"}}},
+			method:       "/test.Echo/Echo",
+			wantUsername: "",
+		},
+		{
+			name:       "denied method",
+			pc:         &grpcProxyConfig{policy: &rpcPolicy{denyMethods: []string{"/test.Echo/Echo"}}},
+			method:     "/test.Echo/Echo",
+			wantCode:   codes.PermissionDenied,
+			wantDenied: true,
+		},
+		{
+			name:     "local server status",
+			pc:       new(grpcProxyConfig),
+			method:   "/test.Echo/Fail",
+			wantCode: codes.NotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := grpc.Dial(local,
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+			if err != nil {
+				t.Fatalf("failed to dial the local server: %v", err)
+			}
+			defer conn.Close()
+			var denied []string
+			p := &grpcProxy{
+				target: tunnel.Target{ID: "srl1", Type: targetTypeGNMI},
+				log:    log.NewEntry(log.StandardLogger()),
+				pc:     tt.pc,
+				conn:   conn,
+				denied: func(method, _ string) { denied = append(denied, method) },
+			}
+			proxy, err := grpc.Dial(serveTestGrpc(t, p.handler),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+			if err != nil {
+				t.Fatalf("failed to dial the proxy: %v", err)
+			}
+			defer proxy.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if tt.username != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "username", tt.username, "password", "remote")
+			}
+			req, resp := &frame{payload: []byte("hello")}, new(frame)
+			var header metadata.MD
+			err = proxy.Invoke(ctx, tt.method, req, resp, grpc.Header(&header))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Invoke() error = %v, want code %s", err, tt.wantCode)
+			}
+			if (len(denied) == 1 && denied[0] == tt.method) != tt.wantDenied {
+				t.Errorf("denied RPCs = %v, want denied %v", denied, tt.wantDenied)
+			}
+			if err != nil {
+				return
+			}
+			if string(resp.payload) != "hello" {
+				t.Errorf("response = %q, want %q", resp.payload, "hello")
+			}
+			if got := header.Get("method"); len(got) != 1 || got[0] != tt.method {
+				t.Errorf("local server method = %v, want %s", got, tt.method)
+			}
+			if got := header.Get("username"); tt.wantUsername == "" && len(got) != 0 || tt.wantUsername != "" && (len(got) != 1 || got[0] != tt.wantUsername) {
+				t.Errorf("local server username = %v, want %q", got, tt.wantUsername)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// number of state transitions kept per object
+const stateHistorySize = 32
+
+// stateTransition is an oper-state change of the app, a tunnel, a tunnel destination or a target.
+type stateTransition struct {
+	Timestamp stringValue `json:"timestamp,omitempty"`
+	OldState  string      `json:"old_state,omitempty"`
+	NewState  string      `json:"new_state,omitempty"`
+	Reason    stringValue `json:"reason,omitempty"`
+}
+
+// stateHistory is the bounded history of the oper-state transitions of an object,
+// the transitions are numbered from 1.
+type stateHistory struct {
+	state string
+	// sequence number of the last transition
+	seq uint64
+	// ring of the last stateHistorySize transitions, oldest first
+	events []*stateTransition
+}
+
+// record appends a transition if state differs from the last recorded one.
+// It returns the sequence number of the new transition, 0 if none was recorded,
+// and the sequence number of the transition evicted from the history, 0 if none.
+func (h *stateHistory) record(state, reason string) (uint64, uint64) {
+	if state == "" || state == h.state {
+		return 0, 0
+	}
+	h.seq++
+	h.events = append(h.events, &stateTransition{
+		Timestamp: stringValue{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+		OldState:  h.state,
+		NewState:  state,
+		Reason:    stringValue{Value: reason},
+	})
+	h.state = state
+	var evicted uint64
+	if len(h.events) > stateHistorySize {
+		h.events = h.events[1:]
+		evicted = h.seq - stateHistorySize
+	}
+	return h.seq, evicted
+}
+
+// stateHistories holds the state histories, indexed by the telemetry path of their object.
+type stateHistories struct {
+	m         *sync.Mutex
+	histories map[string]*stateHistory
+}
+
+func newStateHistories() *stateHistories {
+	return &stateHistories{
+		m:         new(sync.Mutex),
+		histories: make(map[string]*stateHistory),
+	}
+}
+
+func stateHistoryPath(jsPath string, seq uint64) string {
+	return fmt.Sprintf("%s.state_history{.sequence==%d}", jsPath, seq)
+}
+
+// recordStateTransition records the oper-state of obj, whose telemetry path is jsPath.
+// If the state changed, the transition is published and sent to the webhooks.
+func (a *app) recordStateTransition(jsPath string, obj stateObject, state, reason string) {
+	sh := a.stateHistories
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	h, ok := sh.histories[jsPath]
+	if !ok {
+		h = new(stateHistory)
+		sh.histories[jsPath] = h
+	}
+	seq, evicted := h.record(state, reason)
+	if seq == 0 {
+		return
+	}
+	if evicted != 0 {
+		a.deleteTelemetryPath(stateHistoryPath(jsPath, evicted))
+	}
+	st := h.events[len(h.events)-1]
+	a.notifyWebhooks(obj, st)
+	jsData, err := json.Marshal(st)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	a.updateTelemetryPathConfig(stateHistoryPath(jsPath, seq), string(jsData))
+}
+
+// deleteStateHistories forgets the state histories of the object at jsPath and of its descendants,
+// their telemetry is deleted with the object.
+func (a *app) deleteStateHistories(jsPath string) {
+	sh := a.stateHistories
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	for p := range sh.histories {
+		if isTelemetrySubPath(p, jsPath) {
+			delete(sh.histories, p)
+		}
+	}
+}
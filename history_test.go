@@ -0,0 +1,80 @@
+package main
+
+import "testing"
+
+func TestStateHistoryRecord(t *testing.T) {
+	type record struct {
+		state       string
+		wantSeq     uint64
+		wantEvicted uint64
+	}
+	flaps := make([]record, 0, stateHistorySize+2)
+	for i := 1; i <= stateHistorySize+2; i++ {
+		r := record{state: operUp, wantSeq: uint64(i)}
+		if i%2 == 0 {
+			r.state = operDown
+		}
+		if i > stateHistorySize {
+			r.wantEvicted = uint64(i - stateHistorySize)
+		}
+		flaps = append(flaps, r)
+	}
+	tests := []struct {
+		name    string
+		records []record
+		// old state of the oldest kept transition
+		wantFirstOld string
+	}{
+		{
+			name: "first state",
+			records: []record{
+				{state: operDown, wantSeq: 1},
+			},
+		},
+		{
+			name: "unchanged states are not recorded",
+			records: []record{
+				{state: operStarting, wantSeq: 1},
+				{state: operStarting},
+				{state: operUp, wantSeq: 2},
+				{state: ""},
+				{state: operUp},
+			},
+		},
+		{
+			name:         "eviction",
+			records:      flaps,
+			wantFirstOld: operDown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := new(stateHistory)
+			recorded := 0
+			for i, r := range tt.records {
+				seq, evicted := h.record(r.state, "reason")
+				if seq != r.wantSeq || evicted != r.wantEvicted {
+					t.Fatalf("record %d (%s) = %d, %d, want %d, %d", i+1, r.state, seq, evicted, r.wantSeq, r.wantEvicted)
+				}
+				if seq != 0 {
+					recorded++
+				}
+			}
+			wantLen := min(recorded, stateHistorySize)
+			if len(h.events) != wantLen {
+				t.Fatalf("history length = %d, want %d", len(h.events), wantLen)
+			}
+			if h.events[0].OldState != tt.wantFirstOld {
+				t.Errorf("oldest transition old state = %q, want %q", h.events[0].OldState, tt.wantFirstOld)
+			}
+			if got := h.events[len(h.events)-1]; got.NewState != h.state {
+				t.Errorf("last transition new state = %q, want %q", got.NewState, h.state)
+			}
+			for i := 1; i < len(h.events); i++ {
+				if h.events[i].OldState != h.events[i-1].NewState {
+					t.Errorf("transition %d old state = %q, want %q", i, h.events[i].OldState, h.events[i-1].NewState)
+				}
+			}
+		})
+	}
+}
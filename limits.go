@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maximum time a rejected session is kept open for the client to send its first RPC
+const rejectTimeout = time.Second
+
+// sessionLimits restricts the sessions a target accepts.
+type sessionLimits struct {
+	// maximum number of concurrent sessions, 0 means unlimited
+	maxSessions int
+	// new sessions per second, 0 means unlimited
+	rate  float64
+	burst int
+	// sessions are closed after being idle for idleTimeout
+	// or after running for maxLifetime, 0 means no limit
+	idleTimeout time.Duration
+	maxLifetime time.Duration
+}
+
+func newSessionLimits(tg *target) sessionLimits {
+	sl := sessionLimits{
+		maxSessions: int(tg.Target.SessionLimits.MaxSessions.Value),
+		rate:        float64(tg.Target.SessionLimits.Rate.Value),
+		burst:       int(tg.Target.SessionLimits.Burst.Value),
+		idleTimeout: time.Duration(tg.Target.SessionLimits.IdleTimeout.Value) * time.Second,
+		maxLifetime: time.Duration(tg.Target.SessionLimits.MaxLifetime.Value) * time.Second,
+	}
+	if sl.rate > 0 && sl.burst == 0 {
+		sl.burst = int(sl.rate)
+	}
+	return sl
+}
+
+// acquireSession checks the target limits before accepting a new session.
+func (ts *targetStats) acquireSession(sl sessionLimits) error {
+	ts.m.Lock()
+	defer ts.m.Unlock()
+	if sl.maxSessions > 0 && ts.activeSessions >= sl.maxSessions {
+		return fmt.Errorf("maximum number of concurrent sessions (%d) reached", sl.maxSessions)
+	}
+	if sl.rate > 0 {
+		if ts.limiter == nil {
+			ts.limiter = rate.NewLimiter(rate.Limit(sl.rate), sl.burst)
+		} else {
+			ts.limiter.SetLimit(rate.Limit(sl.rate))
+			ts.limiter.SetBurst(sl.burst)
+		}
+		if !ts.limiter.Allow() {
+			return fmt.Errorf("new sessions rate limit (%g/s) exceeded", sl.rate)
+		}
+	}
+	ts.activeSessions++
+	return nil
+}
+
+func (ts *targetStats) releaseSession() {
+	ts.m.Lock()
+	defer ts.m.Unlock()
+	ts.activeSessions--
+}
+
+// rejectsWithStatus returns true if the sessions of ttd are rejected with a gRPC status,
+// i.e. if they carry gRPC.
+func (ttd *tunnelTargetDetails) rejectsWithStatus() bool {
+	return isGrpcType(ttd.Type) || ttd.proxy != nil
+}
+
+// rejectSession ends a session refused by the agent, slog is the session logger.
+// Returning an error from the tunnel client handler tears down the whole tunnel client,
+// so the error is sent as a gRPC status on sessions towards gRPC servers,
+// the other sessions are closed.
+// The gRPC sessions are closed once the status of the first RPC is sent,
+// or after rejectTimeout if the client does not send any.
+func rejectSession(slog *log.Entry, ttd *tunnelTargetDetails, rwc io.ReadWriteCloser, reason error) {
+	if !ttd.rejectsWithStatus() {
+		rwc.Close()
+		return
+	}
+	var s *grpc.Server
+	once := new(sync.Once)
+	stopped := make(chan struct{})
+	s = grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(func(_ any, _ grpc.ServerStream) error {
+			// GracefulStop waits for this handler to return and for the status to be sent
+			once.Do(func() {
+				go func() {
+					s.GracefulStop()
+					close(stopped)
+				}()
+			})
+			return status.Error(codes.ResourceExhausted, reason.Error())
+		}),
+	)
+	l := &rejectListener{sessionListener: newSessionListener(rwc), done: make(chan struct{})}
+	// the session is closed first, the server handshake blocks until it is
+	timer := time.AfterFunc(rejectTimeout, func() {
+		l.sessionListener.Close()
+		s.Stop()
+	})
+	defer timer.Stop()
+	err := s.Serve(l)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Debugf("rejected session error: %v", err)
+	}
+	// Serve returns as soon as GracefulStop closes the listener,
+	// wait for the status to be sent and the session closed
+	select {
+	case <-stopped:
+	case <-l.conn.closed:
+	}
+}
+
+// rejectListener is a sessionListener whose Close does not close the session,
+// so that a server GracefulStop can send the pending statuses before closing it.
+type rejectListener struct {
+	*sessionListener
+	once sync.Once
+	done chan struct{}
+}
+
+func (l *rejectListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.done:
+		return nil, net.ErrClosed
+	default:
+	}
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-l.conn.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *rejectListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestNewSessionLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   sessionLimits
+	}{
+		{
+			name:   "unlimited",
+			target: `{"target":{}}`,
+			want:   sessionLimits{},
+		},
+		{
+			name:   "burst defaults to the rate",
+			target: `{"target":{"session_limits":{"rate":{"value":5},"max_sessions":{"value":"10"}}}}`,
+			want:   sessionLimits{maxSessions: 10, rate: 5, burst: 5},
+		},
+		{
+			name:   "all set",
+			target: `{"target":{"session_limits":{"rate":{"value":5},"burst":{"value":2},"idle_timeout":{"value":30},"max_lifetime":{"value":3600}}}}`,
+			want:   sessionLimits{rate: 5, burst: 2, idleTimeout: 30 * time.Second, maxLifetime: time.Hour},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newSessionLimits(newTestTarget(t, tt.target)); got != tt.want {
+				t.Errorf("newSessionLimits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcquireSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits sessionLimits
+		// sessions released after each accepted one
+		release bool
+		// accepted (true) or rejected (false) result of each attempt
+		want []bool
+	}{
+		{
+			name:   "unlimited",
+			limits: sessionLimits{},
+			want:   []bool{true, true, true, true},
+		},
+		{
+			name:   "max sessions",
+			limits: sessionLimits{maxSessions: 2},
+			want:   []bool{true, true, false, false},
+		},
+		{
+			name:    "max sessions with releases",
+			limits:  sessionLimits{maxSessions: 1},
+			release: true,
+			want:    []bool{true, true, true},
+		},
+		{
+			name:    "rate burst",
+			limits:  sessionLimits{rate: 0.001, burst: 2},
+			release: true,
+			want:    []bool{true, true, false},
+		},
+		{
+			name:   "rate and max sessions",
+			limits: sessionLimits{maxSessions: 1, rate: 0.001, burst: 3},
+			want:   []bool{true, false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &targetStats{m: new(sync.Mutex), traffic: newTrafficShapers()}
+			for i, want := range tt.want {
+				err := ts.acquireSession(tt.limits)
+				if got := err == nil; got != want {
+					t.Fatalf("attempt %d: acquireSession() error = %v, want accepted %v", i+1, err, want)
+				}
+				if err == nil && tt.release {
+					ts.releaseSession()
+				}
+			}
+			if tt.release && ts.activeSessions != 0 {
+				t.Errorf("active sessions = %d, want 0", ts.activeSessions)
+			}
+		})
+	}
+}
+
+func TestRejectsWithStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		ttd  *tunnelTargetDetails
+		want bool
+	}{
+		{name: "gnmi", ttd: &tunnelTargetDetails{Type: targetTypeGNMI}, want: true},
+		{name: "p4rt", ttd: &tunnelTargetDetails{Type: targetTypeP4RT}, want: true},
+		{name: "ssh", ttd: &tunnelTargetDetails{Type: targetTypeSSH}, want: false},
+		{name: "custom", ttd: &tunnelTargetDetails{Type: "http"}, want: false},
+		{name: "custom proxied", ttd: &tunnelTargetDetails{Type: "grpc", proxy: &grpcProxyConfig{}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ttd.rejectsWithStatus(); got != tt.want {
+				t.Errorf("rejectsWithStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRejectSession(t *testing.T) {
+	tests := []struct {
+		name string
+		ttd  *tunnelTargetDetails
+		// the remote end sends an RPC on the session
+		rpc     bool
+		wantErr codes.Code
+		// the session must be closed within
+		within time.Duration
+	}{
+		{name: "raw session", ttd: &tunnelTargetDetails{Type: targetTypeSSH}, within: 100 * time.Millisecond},
+		{name: "grpc status", ttd: &tunnelTargetDetails{Type: targetTypeGNMI}, rpc: true, wantErr: codes.ResourceExhausted, within: rejectTimeout / 2},
+		{name: "no rpc", ttd: &tunnelTargetDetails{Type: targetTypeGNMI}, within: rejectTimeout + time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer remote.Close()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				rejectSession(log.NewEntry(log.StandardLogger()), tt.ttd, local, errors.New("limit reached"))
+			}()
+			if tt.rpc {
+				conn, err := grpc.Dial("passthrough:///session",
+					grpc.WithTransportCredentials(insecure.NewCredentials()),
+					grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return remote, nil }),
+				)
+				if err != nil {
+					t.Fatalf("failed to dial the session: %v", err)
+				}
+				defer conn.Close()
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+				err = conn.Invoke(ctx, gnmiGetMethod, new(frame), new(frame), grpc.ForceCodec(rawCodec{}))
+				if status.Code(err) != tt.wantErr || !strings.Contains(err.Error(), "limit reached") {
+					t.Errorf("Invoke() error = %v, want %s with the reject reason", err, tt.wantErr)
+				}
+			}
+			select {
+			case <-done:
+			case <-time.After(tt.within):
+				t.Fatalf("rejectSession() did not return within %s", tt.within)
+			}
+			if !tt.rpc {
+				remote.SetReadDeadline(time.Now().Add(time.Second))
+				if _, err := io.Copy(io.Discard, remote); err != nil {
+					t.Errorf("session not closed: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// log-level enum values
+const (
+	logLevelError   = "LOG_LEVEL_error"
+	logLevelWarning = "LOG_LEVEL_warning"
+	logLevelInfo    = "LOG_LEVEL_info"
+	logLevelDebug   = "LOG_LEVEL_debug"
+)
+
+// log output formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging sets the format and output of the application logs.
+// If file is set, the logs are written to it and the file is rotated
+// once it reaches maxSize MB, keeping maxBackups rotated files.
+func setupLogging(format, file string, maxSize, maxBackups int) error {
+	switch format {
+	case logFormatText:
+		log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+	case logFormatJSON:
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, expecting %q or %q", format, logFormatText, logFormatJSON)
+	}
+	if file == "" {
+		return nil
+	}
+	rf, err := newRotatingFile(file, int64(maxSize)*1024*1024, maxBackups, 0644)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(rf)
+	return nil
+}
+
+// parseLogLevel returns the logrus level of a log-level enum value,
+// ok is false if the value is not set.
+func parseLogLevel(level string) (log.Level, bool) {
+	switch level {
+	case logLevelError:
+		return log.ErrorLevel, true
+	case logLevelWarning:
+		return log.WarnLevel, true
+	case logLevelInfo:
+		return log.InfoLevel, true
+	case logLevelDebug:
+		return log.DebugLevel, true
+	}
+	return log.InfoLevel, false
+}
+
+// logLevels holds the level of the application logs and of the logs of each tunnel.
+// A tunnel without a configured level follows the application level,
+// which defaults to the level set on the command line.
+type logLevels struct {
+	m *sync.Mutex
+	// level set on the command line
+	base log.Level
+	// configured application level, if any
+	level *log.Level
+	// [tunnelName]
+	tunnels map[string]*tunnelLogger
+}
+
+type tunnelLogger struct {
+	logger *log.Logger
+	// configured tunnel level, if any
+	level *log.Level
+}
+
+func newLogLevels(base log.Level) *logLevels {
+	return &logLevels{
+		m:       new(sync.Mutex),
+		base:    base,
+		tunnels: make(map[string]*tunnelLogger),
+	}
+}
+
+// appLevel returns the application log level, must be called with ll.m held.
+func (ll *logLevels) appLevel() log.Level {
+	if ll.level != nil {
+		return *ll.level
+	}
+	return ll.base
+}
+
+// tunnelLog returns the logger of tunnel tn, with the tunnel name field set.
+func (a *app) tunnelLog(tn string) *log.Entry {
+	ll := a.logLevels
+	ll.m.Lock()
+	defer ll.m.Unlock()
+	tl, ok := ll.tunnels[tn]
+	if !ok {
+		std := log.StandardLogger()
+		l := log.New()
+		// share the output, format and hooks of the standard logger
+		l.Out = std.Out
+		l.Formatter = std.Formatter
+		l.Hooks = std.Hooks
+		l.ReportCaller = std.ReportCaller
+		l.SetLevel(ll.appLevel())
+		tl = &tunnelLogger{logger: l}
+		ll.tunnels[tn] = tl
+	}
+	return tl.logger.WithField("tunnel", tn)
+}
+
+// setLogLevel applies the configured application log level,
+// an empty level restores the command line level.
+func (a *app) setLogLevel(level string) {
+	ll := a.logLevels
+	ll.m.Lock()
+	defer ll.m.Unlock()
+	ll.level = nil
+	if lvl, ok := parseLogLevel(level); ok {
+		ll.level = &lvl
+	}
+	log.SetLevel(ll.appLevel())
+	for _, tl := range ll.tunnels {
+		if tl.level == nil {
+			tl.logger.SetLevel(ll.appLevel())
+		}
+	}
+	log.Infof("log level set to %s", ll.appLevel())
+}
+
+// setTunnelLogLevel applies the configured log level of tunnel tn,
+// an empty level makes the tunnel follow the application level.
+func (a *app) setTunnelLogLevel(tn, level string) {
+	tlog := a.tunnelLog(tn)
+	ll := a.logLevels
+	ll.m.Lock()
+	defer ll.m.Unlock()
+	tl := ll.tunnels[tn]
+	tl.level = nil
+	if lvl, ok := parseLogLevel(level); ok {
+		tl.level = &lvl
+	}
+	newLevel := ll.appLevel()
+	if tl.level != nil {
+		newLevel = *tl.level
+	}
+	if newLevel != tl.logger.GetLevel() {
+		tl.logger.SetLevel(newLevel)
+		tlog.Infof("log level set to %s", newLevel)
+	}
+}
+
+// deleteTunnelLog forgets the logger of tunnel tn.
+func (a *app) deleteTunnelLog(tn string) {
+	a.logLevels.m.Lock()
+	defer a.logLevels.m.Unlock()
+	delete(a.logLevels.tunnels, tn)
+}
+
+// rotatingFile is a log file rotated when it reaches its max size,
+// the rotated files are named <path>.1 (most recent) to <path>.<maxBackups>.
+type rotatingFile struct {
+	m          *sync.Mutex
+	path       string
+	perm       os.FileMode
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int, perm os.FileMode) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{
+		m:          new(sync.Mutex),
+		path:       path,
+		perm:       perm,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, rf.perm)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(b []byte) (int, error) {
+	rf.m.Lock()
+	defer rf.m.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(b)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated files and starts a new file, must be called with rf.m held.
+func (rf *rotatingFile) rotate() error {
+	rf.f.Close()
+	if rf.maxBackups <= 0 {
+		os.Remove(rf.path)
+		return rf.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	// if the rename fails, the current file is reopened and keeps growing
+	os.Rename(rf.path, rf.path+".1")
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.m.Lock()
+	defer rf.m.Unlock()
+	return rf.f.Close()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxSize    int64
+		maxBackups int
+		// existing content of the file
+		existing string
+		writes   []string
+		// content of the file then of the rotated files, most recent first
+		want []string
+	}{
+		{
+			name:       "no rotation",
+			maxSize:    10,
+			maxBackups: 2,
+			writes:     []string{"aaaa", "bbbb"},
+			want:       []string{"aaaabbbb"},
+		},
+		{
+			name:       "rotation",
+			maxSize:    10,
+			maxBackups: 2,
+			writes:     []string{"aaaa", "bbbb", "cccc"},
+			want:       []string{"cccc", "aaaabbbb"},
+		},
+		{
+			name:       "oldest backup removed",
+			maxSize:    4,
+			maxBackups: 2,
+			writes:     []string{"aaaa", "bbbb", "cccc", "dddd"},
+			want:       []string{"dddd", "cccc", "bbbb"},
+		},
+		{
+			name:       "no backups",
+			maxSize:    4,
+			maxBackups: 0,
+			writes:     []string{"aaaa", "bbbb"},
+			want:       []string{"bbbb"},
+		},
+		{
+			name:       "write larger than the max size",
+			maxSize:    4,
+			maxBackups: 1,
+			writes:     []string{"aaaaaaaa", "bb"},
+			want:       []string{"bb", "aaaaaaaa"},
+		},
+		{
+			name:       "existing file size",
+			maxSize:    10,
+			maxBackups: 1,
+			existing:   "zzzzzzzz",
+			writes:     []string{"aaaa"},
+			want:       []string{"aaaa", "zzzzzzzz"},
+		},
+		{
+			name:       "unlimited size",
+			maxSize:    0,
+			maxBackups: 1,
+			writes:     []string{"aaaa", "bbbb"},
+			want:       []string{"aaaabbbb"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "logs", "app.log")
+			if tt.existing != "" {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rf, err := newRotatingFile(path, tt.maxSize, tt.maxBackups, 0600)
+			if err != nil {
+				t.Fatalf("newRotatingFile() error = %v", err)
+			}
+			defer rf.Close()
+			for _, w := range tt.writes {
+				n, err := rf.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			for i, want := range tt.want {
+				p := path
+				if i > 0 {
+					p = fmt.Sprintf("%s.%d", path, i)
+				}
+				b, err := os.ReadFile(p)
+				if err != nil {
+					t.Fatalf("failed to read %s: %v", p, err)
+				}
+				if string(b) != want {
+					t.Errorf("%s = %q, want %q", filepath.Base(p), b, want)
+				}
+			}
+			// no extra backup is kept
+			extra := fmt.Sprintf("%s.%d", path, len(tt.want))
+			if _, err := os.Stat(extra); !os.IsNotExist(err) {
+				t.Errorf("unexpected file %s: %v", filepath.Base(extra), err)
+			}
+		})
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"flag"
 	"fmt"
-
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	agent "github.com/karimra/srl-ndk-demo"
@@ -24,12 +26,45 @@ var debug *bool
 func main() {
 	debug = flag.Bool("d", false, "turn on debug")
 	versionFlag := flag.Bool("v", false, "print version")
+	adminSocket := flag.String("admin-socket", defaultAdminSocket, "admin API unix socket path")
+	logFormat := flag.String("log-format", logFormatText, "log format, text or json")
+	logFile := flag.String("log-file", "", "log file path, the logs are written to stderr if not set")
+	logMaxSize := flag.Int("log-max-size", 10, "log file size in MB after which it is rotated")
+	logMaxBackups := flag.Int("log-max-backups", 3, "number of rotated log files kept")
 	flag.Parse()
 
 	if *versionFlag {
 		fmt.Println(version)
 		return
 	}
+	switch flag.Arg(0) {
+	case "":
+	case "status":
+		if err := runStatus(flag.Args()[1:], *adminSocket, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "validate":
+		if err := runValidate(flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	case "diag":
+		if err := runDiag(flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", flag.Arg(0))
+		os.Exit(1)
+	}
+	if err := setupLogging(*logFormat, *logFile, *logMaxSize, *logMaxBackups); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	if *debug {
 		log.SetLevel(log.DebugLevel)
 		log.SetReportCaller(true)
@@ -39,37 +74,73 @@ func main() {
 	defer cancel()
 	ctx = metadata.AppendToOutgoingContext(ctx, "agent_name", agentName)
 
-CRAGENT:
-	app, err := agent.New(ctx, agentName)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	app, err := newAgent(ctx, sigs)
 	if err != nil {
-		log.Errorf("failed to create agent %q: %v", agentName, err)
-		log.Infof("retrying in %s", retryInterval)
-		time.Sleep(retryInterval)
-		goto CRAGENT
+		log.Info(err)
+		return
 	}
 
 	a := newApp(ctx, WithAgent(app))
+	go a.serveAdmin(ctx, *adminSocket)
+	// the services watcher is stopped before shutdown,
+	// so that it does not add or delete targets while they are deleted.
+	svcCtx, stopServices := context.WithCancel(ctx)
+	svcDone := make(chan struct{})
 	//
 	go func() {
+		defer close(svcDone)
 		for {
 			select {
-			case <-ctx.Done():
+			case <-svcCtx.Done():
 				return
 			default:
 				log.Info("getting system info...")
-				sysInfo, err := a.getSystemInfo(ctx)
+				sysInfo, err := a.getSystemInfo(svcCtx)
 				if err != nil {
 					log.Errorf("failed to get system info %q: %v", agentName, err)
 					log.Infof("retrying in %s", retryInterval)
-					time.Sleep(retryInterval)
+					select {
+					case <-svcCtx.Done():
+					case <-time.After(retryInterval):
+					}
 					continue
 				}
 				log.Infof("system info: %+v", sysInfo)
 				a.config.sysInfo = *sysInfo
+				// the tunnels configured meanwhile get their auto targets once the services are discovered,
+				// watchServices is started after the initial snapshot so that it cannot overwrite a newer one.
+				a.refreshServices(svcCtx)
+				a.watchServices(svcCtx)
 				return
 			}
 		}
 	}()
 	log.Info("starting config handler...")
-	a.start(ctx)
+	a.start(ctx, sigs)
+	stopServices()
+	<-svcDone
+	a.shutdown(shutdownTimeout, cancel)
+}
+
+// newAgent registers the agent with the NDK, retrying until it succeeds.
+// It gives up if a signal is received on sigs or if ctx is done.
+func newAgent(ctx context.Context, sigs <-chan os.Signal) (*agent.Agent, error) {
+	for {
+		app, err := agent.New(ctx, agentName)
+		if err == nil {
+			return app, nil
+		}
+		log.Errorf("failed to create agent %q: %v", agentName, err)
+		log.Infof("retrying in %s", retryInterval)
+		select {
+		case sig := <-sigs:
+			return nil, fmt.Errorf("received signal %s while creating the agent, exiting", sig)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	gnmiGetMethod       = "/gnmi.gNMI/Get"
+	gnmiSubscribeMethod = "/gnmi.gNMI/Subscribe"
+)
+
+// rpcPolicy restricts the RPCs a remote client can run through a gRPC proxy target.
+type rpcPolicy struct {
+	// full gRPC method names or glob patterns
+	allowMethods []string
+	denyMethods  []string
+	// gNMI paths allowed in Get and Subscribe requests
+	pathPrefixes []*gnmi.Path
+}
+
+// newRPCPolicy returns the RPC policy of a target,
+// nil if the target does not have a policy configured.
+func newRPCPolicy(tg *target) *rpcPolicy {
+	rp := tg.Target.RPCPolicy
+	if len(rp.AllowMethod) == 0 && len(rp.DenyMethod) == 0 && len(rp.GnmiPathPrefix) == 0 {
+		return nil
+	}
+	p := &rpcPolicy{
+		allowMethods: make([]string, 0, len(rp.AllowMethod)),
+		denyMethods:  make([]string, 0, len(rp.DenyMethod)),
+		pathPrefixes: make([]*gnmi.Path, 0, len(rp.GnmiPathPrefix)),
+	}
+	for _, m := range rp.AllowMethod {
+		p.allowMethods = append(p.allowMethods, m.Value)
+	}
+	for _, m := range rp.DenyMethod {
+		p.denyMethods = append(p.denyMethods, m.Value)
+	}
+	for _, pp := range rp.GnmiPathPrefix {
+		gp, err := parsePathPrefix(pp.Value)
+		if err != nil {
+			// the targets with an invalid prefix are rejected by checkTarget
+			continue
+		}
+		p.pathPrefixes = append(p.pathPrefixes, gp)
+	}
+	return p
+}
+
+// checkRPCPolicy returns an error if a gNMI path prefix of the target RPC policy is invalid.
+func checkRPCPolicy(tg *target) error {
+	for _, pp := range tg.Target.RPCPolicy.GnmiPathPrefix {
+		if _, err := parsePathPrefix(pp.Value); err != nil {
+			return fmt.Errorf("invalid gnmi-path-prefix %q: %v", pp.Value, err)
+		}
+	}
+	return nil
+}
+
+// parsePathPrefix parses a gNMI path prefix, with an optional origin and keys,
+// e.g: /interface[name=mgmt0], openconfig:/interfaces.
+func parsePathPrefix(s string) (*gnmi.Path, error) {
+	return utils.ParsePath(strings.TrimSpace(s))
+}
+
+// allowMethod checks a full gRPC method name against the policy,
+// deny entries take precedence over allow entries.
+// If allow entries are set, any method not matching them is denied.
+func (p *rpcPolicy) allowMethod(method string) bool {
+	if matchMethod(p.denyMethods, method) {
+		return false
+	}
+	if len(p.allowMethods) == 0 {
+		return true
+	}
+	return matchMethod(p.allowMethods, method)
+}
+
+func matchMethod(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if pattern == method {
+			return true
+		}
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// inspects returns true if the messages of method
+// must be checked against the gNMI path prefixes.
+func (p *rpcPolicy) inspects(method string) bool {
+	if len(p.pathPrefixes) == 0 {
+		return false
+	}
+	return method == gnmiGetMethod || method == gnmiSubscribeMethod
+}
+
+// allowMessage decodes a gNMI Get or Subscribe request
+// and checks that all its paths are under an allowed prefix.
+// Subscribe Poll and Aliases messages do not carry paths, they are allowed:
+// the subscription they apply to was checked when it was created.
+func (p *rpcPolicy) allowMessage(method string, payload []byte) (string, bool) {
+	var prefix *gnmi.Path
+	var paths []*gnmi.Path
+	switch method {
+	case gnmiGetMethod:
+		req := new(gnmi.GetRequest)
+		if err := proto.Unmarshal(payload, req); err != nil {
+			return "", false
+		}
+		prefix = req.GetPrefix()
+		paths = req.GetPath()
+	case gnmiSubscribeMethod:
+		req := new(gnmi.SubscribeRequest)
+		if err := proto.Unmarshal(payload, req); err != nil {
+			return "", false
+		}
+		if req.GetSubscribe() == nil {
+			return "", true
+		}
+		prefix = req.GetSubscribe().GetPrefix()
+		for _, sub := range req.GetSubscribe().GetSubscription() {
+			paths = append(paths, sub.GetPath())
+		}
+	default:
+		return "", true
+	}
+	if len(paths) == 0 {
+		paths = append(paths, &gnmi.Path{})
+	}
+	for _, pt := range paths {
+		rp := &gnmi.Path{
+			Origin: prefix.GetOrigin(),
+			Elem:   append(append([]*gnmi.PathElem{}, prefix.GetElem()...), pt.GetElem()...),
+		}
+		if rp.Origin == "" {
+			rp.Origin = pt.GetOrigin()
+		}
+		if !p.allowPath(rp) {
+			return pathString(rp), false
+		}
+	}
+	return "", true
+}
+
+// allowPath checks that the request path rp is under one of the policy prefixes.
+// A prefix with an origin only matches the requests with the same origin,
+// a prefix without origin matches any origin. The path target is ignored.
+// A prefix element with keys only matches the request elements with the same key values,
+// wildcards included: /interface[name=mgmt0] does not allow /interface[name=*].
+func (p *rpcPolicy) allowPath(rp *gnmi.Path) bool {
+PREFIXES:
+	for _, pp := range p.pathPrefixes {
+		if pp.GetOrigin() != "" && pp.GetOrigin() != rp.GetOrigin() {
+			continue
+		}
+		if len(pp.GetElem()) > len(rp.GetElem()) {
+			continue
+		}
+		for i, pe := range pp.GetElem() {
+			re := rp.GetElem()[i]
+			if pe.GetName() != re.GetName() {
+				continue PREFIXES
+			}
+			for k, v := range pe.GetKey() {
+				if re.GetKey()[k] != v {
+					continue PREFIXES
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// pathString returns the xpath of a gNMI path, with its origin and keys.
+func pathString(gp *gnmi.Path) string {
+	xp := "/" + utils.GnmiPathToXPath(&gnmi.Path{Elem: gp.GetElem()}, false)
+	if gp.GetOrigin() != "" {
+		return gp.GetOrigin() + ":" + xp
+	}
+	return xp
+}
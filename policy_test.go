@@ -0,0 +1,352 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRPCPolicyAllowMethod(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *rpcPolicy
+		method string
+		want   bool
+	}{
+		{
+			name:   "no entries",
+			policy: &rpcPolicy{},
+			method: "/gnmi.gNMI/Set",
+			want:   true,
+		},
+		{
+			name:   "allowed",
+			policy: &rpcPolicy{allowMethods: []string{"/gnmi.gNMI/Get", "/gnmi.gNMI/Subscribe"}},
+			method: "/gnmi.gNMI/Subscribe",
+			want:   true,
+		},
+		{
+			name:   "not allowed",
+			policy: &rpcPolicy{allowMethods: []string{"/gnmi.gNMI/Get"}},
+			method: "/gnmi.gNMI/Set",
+			want:   false,
+		},
+		{
+			name:   "allowed by glob",
+			policy: &rpcPolicy{allowMethods: []string{"/gnoi.system.System/*"}},
+			method: "/gnoi.system.System/Time",
+			want:   true,
+		},
+		{
+			name:   "denied",
+			policy: &rpcPolicy{denyMethods: []string{"/gnmi.gNMI/Set"}},
+			method: "/gnmi.gNMI/Set",
+			want:   false,
+		},
+		{
+			name:   "deny takes precedence",
+			policy: &rpcPolicy{allowMethods: []string{"/gnoi.*/*"}, denyMethods: []string{"/gnoi.system.System/Reboot"}},
+			method: "/gnoi.system.System/Reboot",
+			want:   false,
+		},
+		{
+			name:   "not denied",
+			policy: &rpcPolicy{denyMethods: []string{"/gnoi.*/*"}},
+			method: "/gnmi.gNMI/Get",
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.allowMethod(tt.method); got != tt.want {
+				t.Errorf("allowMethod(%q) = %v, want %v", tt.method, got, tt.want)
+			}
+		})
+	}
+}
+
+func testPath(t *testing.T, xpath string) *gnmi.Path {
+	t.Helper()
+	p, err := utils.ParsePath(xpath)
+	if err != nil {
+		t.Fatalf("failed to parse path %s: %v", xpath, err)
+	}
+	return p
+}
+
+func testMarshal(t *testing.T, m proto.Message) []byte {
+	t.Helper()
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatalf("failed to marshal %v: %v", m, err)
+	}
+	return b
+}
+
+// testPolicy returns a policy allowing the gNMI path prefixes.
+func testPolicy(t *testing.T, prefixes ...string) *rpcPolicy {
+	t.Helper()
+	p := new(rpcPolicy)
+	for _, pp := range prefixes {
+		gp, err := parsePathPrefix(pp)
+		if err != nil {
+			t.Fatalf("failed to parse path prefix %s: %v", pp, err)
+		}
+		p.pathPrefixes = append(p.pathPrefixes, gp)
+	}
+	return p
+}
+
+func TestRPCPolicyAllowMessage(t *testing.T) {
+	policy := testPolicy(t, "/interface", "/system/name")
+	tests := []struct {
+		name     string
+		policy   *rpcPolicy
+		method   string
+		payload  []byte
+		want     bool
+		wantPath string
+	}{
+		{
+			name:   "get allowed path",
+			policy: policy,
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Path: []*gnmi.Path{testPath(t, "/interface[name=ethernet-1/1]/statistics")},
+			}),
+			want: true,
+		},
+		{
+			name:   "get with prefix",
+			policy: policy,
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Prefix: testPath(t, "/system"),
+				Path:   []*gnmi.Path{testPath(t, "/name/host-name")},
+			}),
+			want: true,
+		},
+		{
+			name:   "get denied path",
+			policy: policy,
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Path: []*gnmi.Path{testPath(t, "/interface"), testPath(t, "/system/aaa")},
+			}),
+			want:     false,
+			wantPath: "/system/aaa",
+		},
+		{
+			name:   "prefix is not a path element prefix",
+			policy: policy,
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Path: []*gnmi.Path{testPath(t, "/system/name-server")},
+			}),
+			want:     false,
+			wantPath: "/system/name-server",
+		},
+		{
+			name:    "get root denied",
+			policy:  policy,
+			method:  gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{}),
+			want:    false,
+		},
+		{
+			name:    "get root allowed",
+			policy:  testPolicy(t, "/"),
+			method:  gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{}),
+			want:    true,
+		},
+		{
+			name:   "subscribe denied path",
+			policy: policy,
+			method: gnmiSubscribeMethod,
+			payload: testMarshal(t, &gnmi.SubscribeRequest{
+				Request: &gnmi.SubscribeRequest_Subscribe{
+					Subscribe: &gnmi.SubscriptionList{
+						Subscription: []*gnmi.Subscription{
+							{Path: testPath(t, "/interface/oper-state")},
+							{Path: testPath(t, "/network-instance")},
+						},
+					},
+				},
+			}),
+			want:     false,
+			wantPath: "/network-instance",
+		},
+		{
+			name:   "subscribe allowed paths",
+			policy: policy,
+			method: gnmiSubscribeMethod,
+			payload: testMarshal(t, &gnmi.SubscribeRequest{
+				Request: &gnmi.SubscribeRequest_Subscribe{
+					Subscribe: &gnmi.SubscriptionList{
+						Prefix:       testPath(t, "/interface[name=*]"),
+						Subscription: []*gnmi.Subscription{{Path: testPath(t, "/oper-state")}},
+					},
+				},
+			}),
+			want: true,
+		},
+		{
+			name:   "subscribe root denied",
+			policy: policy,
+			method: gnmiSubscribeMethod,
+			payload: testMarshal(t, &gnmi.SubscribeRequest{
+				Request: &gnmi.SubscribeRequest_Subscribe{
+					Subscribe: &gnmi.SubscriptionList{Mode: gnmi.SubscriptionList_POLL},
+				},
+			}),
+			want:     false,
+			wantPath: "/",
+		},
+		{
+			name:   "subscribe poll",
+			policy: policy,
+			method: gnmiSubscribeMethod,
+			payload: testMarshal(t, &gnmi.SubscribeRequest{
+				Request: &gnmi.SubscribeRequest_Poll{Poll: &gnmi.Poll{}},
+			}),
+			want: true,
+		},
+		{
+			name:   "keyed prefix",
+			policy: testPolicy(t, "/interface[name=mgmt0]", "/network-instance[name=default]/protocols/bgp"),
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Prefix: testPath(t, "/network-instance[name=default]"),
+				Path:   []*gnmi.Path{testPath(t, "/protocols/bgp/neighbor"), testPath(t, "/interface[name=mgmt0]/statistics")},
+			}),
+			want: false,
+			// the prefix elements are prepended to the path
+			wantPath: "/network-instance[name=default]/interface[name=mgmt0]/statistics",
+		},
+		{
+			name:   "keyed prefix allowed",
+			policy: testPolicy(t, "/interface[name=mgmt0]"),
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Path: []*gnmi.Path{testPath(t, "/interface[name=mgmt0]/statistics")},
+			}),
+			want: true,
+		},
+		{
+			name:   "keyed prefix other key",
+			policy: testPolicy(t, "/interface[name=mgmt0]"),
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Path: []*gnmi.Path{testPath(t, "/interface[name=ethernet-1/1]")},
+			}),
+			want:     false,
+			wantPath: "/interface[name=ethernet-1/1]",
+		},
+		{
+			name:   "keyed prefix wildcard",
+			policy: testPolicy(t, "/interface[name=mgmt0]"),
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Path: []*gnmi.Path{testPath(t, "/interface")},
+			}),
+			want:     false,
+			wantPath: "/interface",
+		},
+		{
+			name:   "origin prefix",
+			policy: testPolicy(t, "openconfig:/interfaces"),
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Prefix: testPath(t, "openconfig:/"),
+				Path:   []*gnmi.Path{testPath(t, "/interfaces/interface")},
+			}),
+			want: true,
+		},
+		{
+			name:   "origin prefix other origin",
+			policy: testPolicy(t, "openconfig:/interfaces"),
+			method: gnmiGetMethod,
+			payload: testMarshal(t, &gnmi.GetRequest{
+				Path: []*gnmi.Path{testPath(t, "/interfaces/interface")},
+			}),
+			want:     false,
+			wantPath: "/interfaces/interface",
+		},
+		{
+			name:   "prefix without origin",
+			policy: policy,
+			method: gnmiSubscribeMethod,
+			payload: testMarshal(t, &gnmi.SubscribeRequest{
+				Request: &gnmi.SubscribeRequest_Subscribe{
+					Subscribe: &gnmi.SubscriptionList{
+						Subscription: []*gnmi.Subscription{{Path: testPath(t, "srl_nokia:/interface")}},
+					},
+				},
+			}),
+			want: true,
+		},
+		{
+			name:    "invalid payload",
+			policy:  policy,
+			method:  gnmiGetMethod,
+			payload: []byte{0xff, 0xff},
+			want:    false,
+		},
+		{
+			name:    "other method",
+			policy:  policy,
+			method:  "/gnmi.gNMI/Capabilities",
+			payload: []byte{0xff},
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPath, got := tt.policy.allowMessage(tt.method, tt.payload)
+			if got != tt.want {
+				t.Errorf("allowMessage() = %v, want %v", got, tt.want)
+			}
+			if tt.wantPath != "" && gotPath != tt.wantPath {
+				t.Errorf("allowMessage() path = %q, want %q", gotPath, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestCheckRPCPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		wantErr bool
+	}{
+		{
+			name:   "no policy",
+			target: `{"target":{}}`,
+		},
+		{
+			name:   "valid prefixes",
+			target: `{"target":{"rpc_policy":{"gnmi_path_prefix":[{"value":"/"},{"value":"interface[name=mgmt0]/statistics"},{"value":"openconfig:/interfaces"}]}}}`,
+		},
+		{
+			name:    "malformed key",
+			target:  `{"target":{"rpc_policy":{"gnmi_path_prefix":[{"value":"/interface[name=mgmt0"}]}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "key without value",
+			target:  `{"target":{"rpc_policy":{"gnmi_path_prefix":[{"value":"/interface[name]"}]}}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkRPCPolicy(newTestTarget(t, tt.target))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkRPCPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
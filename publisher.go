@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	telemetryPublishInterval = 500 * time.Millisecond
+	// maximum number of JS paths in a single telemetry request
+	telemetryMaxBatchSize = 200
+)
+
+type telemetryOpType int
+
+const (
+	telemetryOpUpdate telemetryOpType = iota
+	telemetryOpDelete
+)
+
+type telemetryOp struct {
+	typ telemetryOpType
+	// empty if the op was superseded by a later delete
+	jsPath string
+}
+
+// telemetryPublisher queues the telemetry updates and deletes,
+// they are sent to NDK in batches by the publishLoop.
+// Repeated updates to the same JS path are coalesced,
+// and the order between updates and deletes is kept.
+type telemetryPublisher struct {
+	m   *sync.Mutex
+	ops []telemetryOp
+	// JS path to the index in ops of its queued update
+	updates map[string]int
+}
+
+func newTelemetryPublisher() *telemetryPublisher {
+	return &telemetryPublisher{
+		m:       new(sync.Mutex),
+		ops:     make([]telemetryOp, 0),
+		updates: make(map[string]int),
+	}
+}
+
+// update queues an update of jsPath, its data is read from the telemetry cache when published.
+func (tp *telemetryPublisher) update(jsPath string) {
+	tp.m.Lock()
+	defer tp.m.Unlock()
+	if _, ok := tp.updates[jsPath]; ok {
+		return
+	}
+	tp.updates[jsPath] = len(tp.ops)
+	tp.ops = append(tp.ops, telemetryOp{typ: telemetryOpUpdate, jsPath: jsPath})
+}
+
+// delete queues a delete of jsPath, it supersedes the queued updates of jsPath and its descendants.
+func (tp *telemetryPublisher) delete(jsPath string) {
+	tp.m.Lock()
+	defer tp.m.Unlock()
+	for p, i := range tp.updates {
+		if isTelemetrySubPath(p, jsPath) {
+			tp.ops[i].jsPath = ""
+			delete(tp.updates, p)
+		}
+	}
+	if n := len(tp.ops); n > 0 && tp.ops[n-1].typ == telemetryOpDelete && tp.ops[n-1].jsPath == jsPath {
+		return
+	}
+	tp.ops = append(tp.ops, telemetryOp{typ: telemetryOpDelete, jsPath: jsPath})
+}
+
+// take returns the queued ops and empties the queue.
+func (tp *telemetryPublisher) take() []telemetryOp {
+	tp.m.Lock()
+	defer tp.m.Unlock()
+	ops := tp.ops
+	tp.ops = make([]telemetryOp, 0)
+	tp.updates = make(map[string]int)
+	return ops
+}
+
+// publishLoop sends the queued telemetry ops every telemetryPublishInterval.
+func (a *app) publishLoop(ctx context.Context) {
+	ticker := time.NewTicker(telemetryPublishInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.flushTelemetry()
+		}
+	}
+}
+
+// flushTelemetry sends the queued ops in order,
+// consecutive ops of the same type are grouped in a single request.
+func (a *app) flushTelemetry() {
+	ops := a.publisher.take()
+	batch := make([]string, 0, telemetryMaxBatchSize)
+	var batchType telemetryOpType
+	for _, op := range ops {
+		if op.jsPath == "" {
+			continue
+		}
+		if len(batch) > 0 && (op.typ != batchType || len(batch) == telemetryMaxBatchSize) {
+			a.sendTelemetryBatch(batchType, batch)
+			batch = make([]string, 0, telemetryMaxBatchSize)
+		}
+		batchType = op.typ
+		batch = append(batch, op.jsPath)
+	}
+	if len(batch) > 0 {
+		a.sendTelemetryBatch(batchType, batch)
+	}
+}
+
+func (a *app) sendTelemetryBatch(typ telemetryOpType, jsPaths []string) {
+	switch typ {
+	case telemetryOpUpdate:
+		infos := make([]*ndk.TelemetryInfo, 0, len(jsPaths))
+		sent := make([]string, 0, len(jsPaths))
+		for _, p := range jsPaths {
+			// deleted since it was queued
+			jsData, ok := a.telemetry.get(p)
+			if !ok {
+				continue
+			}
+			infos = append(infos, &ndk.TelemetryInfo{
+				Key:  &ndk.TelemetryKey{JsPath: p},
+				Data: &ndk.TelemetryData{JsonContent: jsData},
+			})
+			sent = append(sent, p)
+		}
+		if len(infos) == 0 {
+			return
+		}
+		err := a.publishTelemetry(infos)
+		if err != nil {
+			log.Errorf("failed to update %d telemetry path(s): %v", len(sent), err)
+			for _, p := range sent {
+				a.telemetry.updateFailed(p)
+			}
+		}
+	case telemetryOpDelete:
+		err := a.removeTelemetry(jsPaths...)
+		if err != nil {
+			log.Errorf("failed to delete %d telemetry path(s): %v", len(jsPaths), err)
+			for _, p := range jsPaths {
+				a.telemetry.deleteFailed(p)
+			}
+		}
+	}
+}
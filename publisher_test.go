@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	agent "github.com/karimra/srl-ndk-demo"
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	"google.golang.org/grpc"
+)
+
+func TestTelemetryPublisher(t *testing.T) {
+	const (
+		t1   = `.system.grpc_tunnel.tunnel{.name=="t1"}`
+		t1d1 = `.system.grpc_tunnel.tunnel{.name=="t1"}.destination{.name=="d1"}`
+		t2   = `.system.grpc_tunnel.tunnel{.name=="t2"}`
+	)
+	update := func(p string) telemetryOp { return telemetryOp{typ: telemetryOpUpdate, jsPath: p} }
+	del := func(p string) telemetryOp { return telemetryOp{typ: telemetryOpDelete, jsPath: p} }
+	tests := []struct {
+		name string
+		ops  []telemetryOp
+		// queued ops, superseded ones included
+		want []telemetryOp
+	}{
+		{
+			name: "updates coalesced",
+			ops:  []telemetryOp{update(t1), update(t2), update(t1)},
+			want: []telemetryOp{update(t1), update(t2)},
+		},
+		{
+			name: "delete supersedes the updates of the descendants",
+			ops:  []telemetryOp{update(t1), update(t1d1), update(t2), del(t1)},
+			want: []telemetryOp{{typ: telemetryOpUpdate}, {typ: telemetryOpUpdate}, update(t2), del(t1)},
+		},
+		{
+			name: "update after delete",
+			ops:  []telemetryOp{update(t1), del(t1), update(t1)},
+			want: []telemetryOp{{typ: telemetryOpUpdate}, del(t1), update(t1)},
+		},
+		{
+			name: "repeated deletes coalesced",
+			ops:  []telemetryOp{del(t1), del(t1), del(t2), del(t1)},
+			want: []telemetryOp{del(t1), del(t2), del(t1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp := newTelemetryPublisher()
+			for _, op := range tt.ops {
+				if op.typ == telemetryOpUpdate {
+					tp.update(op.jsPath)
+				} else {
+					tp.delete(op.jsPath)
+				}
+			}
+			if got := tp.take(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queued %+v, want %+v", got, tt.want)
+			}
+			if got := tp.take(); len(got) != 0 {
+				t.Errorf("queue not emptied: %+v", got)
+			}
+		})
+	}
+}
+
+// testTelemetryNDK records the telemetry requests, as "update" or "delete" and their JS paths.
+type testTelemetryNDK struct {
+	ndk.SdkMgrTelemetryServiceClient
+	requests [][]string
+	// returned by the requests if set
+	err error
+}
+
+func (n *testTelemetryNDK) TelemetryAddOrUpdate(_ context.Context, req *ndk.TelemetryUpdateRequest, _ ...grpc.CallOption) (*ndk.TelemetryUpdateResponse, error) {
+	r := []string{"update"}
+	for _, info := range req.GetState() {
+		r = append(r, info.GetKey().GetJsPath())
+	}
+	n.requests = append(n.requests, r)
+	return &ndk.TelemetryUpdateResponse{}, n.err
+}
+
+func (n *testTelemetryNDK) TelemetryDelete(_ context.Context, req *ndk.TelemetryDeleteRequest, _ ...grpc.CallOption) (*ndk.TelemetryDeleteResponse, error) {
+	r := []string{"delete"}
+	for _, k := range req.GetKey() {
+		r = append(r, k.GetJsPath())
+	}
+	n.requests = append(n.requests, r)
+	return &ndk.TelemetryDeleteResponse{}, n.err
+}
+
+func TestFlushTelemetry(t *testing.T) {
+	a := newTestApp()
+	n := new(testTelemetryNDK)
+	a.agent = &agent.Agent{TelemetryServiceClient: n}
+	for _, p := range []string{"a", "b", "c"} {
+		a.updateTelemetryPathConfig(p, `{}`)
+	}
+	a.deleteTelemetryPath("b")
+	a.deleteTelemetryPath("d")
+	a.updateTelemetryPathConfig("e", `{}`)
+	a.updateTelemetryPathConfig("a", `{"description":{"value":"updated"}}`)
+	// deleted from the cache after being queued
+	a.updateTelemetryPathConfig("f", `{}`)
+	a.telemetry.remove("f")
+	a.flushTelemetry()
+	want := [][]string{
+		{"update", "a", "c"},
+		{"delete", "b", "d"},
+		{"update", "e"},
+	}
+	if !reflect.DeepEqual(n.requests, want) {
+		t.Errorf("requests = %v, want %v", n.requests, want)
+	}
+	// nothing queued
+	n.requests = nil
+	a.flushTelemetry()
+	if len(n.requests) != 0 {
+		t.Errorf("requests = %v, want none", n.requests)
+	}
+	// the batches are split at telemetryMaxBatchSize
+	for i := 0; i < telemetryMaxBatchSize+1; i++ {
+		a.updateTelemetryPathConfig(fmt.Sprintf("p%03d", i), `{}`)
+	}
+	a.flushTelemetry()
+	if len(n.requests) != 2 || len(n.requests[0]) != telemetryMaxBatchSize+1 || len(n.requests[1]) != 2 {
+		t.Errorf("sent %d requests, want 2 with %d and 1 paths", len(n.requests), telemetryMaxBatchSize)
+	}
+	// the failed writes are retried
+	n.requests, n.err = nil, errors.New("unavailable")
+	a.updateTelemetryPathConfig("a", `{}`)
+	a.deleteTelemetryPath("c")
+	a.flushTelemetry()
+	updates, deletes := a.telemetry.pending()
+	if !reflect.DeepEqual(updates, []string{"a"}) || !reflect.DeepEqual(deletes, []string{"c"}) {
+		t.Errorf("pending() = %v, %v, want [a], [c]", updates, deletes)
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/utils"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// predefined target types
+const (
+	targetTypeGNMI    = "GNMI_GNOI"
+	targetTypeSSH     = "SSH"
+	targetTypeGRIBI   = "GRIBI"
+	targetTypeP4RT    = "P4_RUNTIME"
+	targetTypeJSONRPC = "JSON_RPC"
+	targetTypeNETCONF = "NETCONF"
+)
+
+// isGrpcType returns true if the sessions of a predefined target type carry gRPC,
+// i.e. if they can go through a gRPC proxy.
+func isGrpcType(typ string) bool {
+	switch typ {
+	case targetTypeGNMI, targetTypeGRIBI, targetTypeP4RT:
+		return true
+	}
+	return false
+}
+
+// default local addresses of the predefined target types,
+// used when a service address could not be discovered.
+var defaultLocalAddresses = map[string]string{
+	targetTypeGNMI:    gnmiServerUnixSocket,
+	targetTypeSSH:     "localhost:22",
+	targetTypeGRIBI:   "localhost:57401",
+	targetTypeP4RT:    "localhost:9559",
+	targetTypeJSONRPC: "localhost:80",
+	targetTypeNETCONF: "localhost:830",
+}
+
+// management servers configuration paths
+var servicesPaths = []*gnmi.Path{
+	{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "gnmi-server"}}},
+	{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "gribi-server"}}},
+	{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "p4rt-server"}}},
+	{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "json-rpc-server"}}},
+	{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "netconf-server"}}},
+	{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "ssh-server"}}},
+}
+
+// serviceServer maps a management server configuration container to the target type it serves.
+var serviceServer = map[string]string{
+	"gnmi-server":     targetTypeGNMI,
+	"gribi-server":    targetTypeGRIBI,
+	"p4rt-server":     targetTypeP4RT,
+	"json-rpc-server": targetTypeJSONRPC,
+	"netconf-server":  targetTypeNETCONF,
+	"ssh-server":      targetTypeSSH,
+}
+
+// default port of the json-rpc-server https transport
+const defaultJSONRPCHTTPSPort = "443"
+
+// serviceInstance is a management server enabled in a network instance.
+type serviceInstance struct {
+	adminState string
+	port       string
+}
+
+// localService is a management server the local targets can be bridged to.
+type localService struct {
+	Type    string
+	Address string
+	// network instances the server is enabled in
+	NetworkInstances []string
+	UnixSocket       bool
+}
+
+// withGnmiCredentials returns ctx carrying the credentials sent to the local gNMI server.
+func withGnmiCredentials(ctx context.Context, username, password string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx,
+		"username", username,
+		"password", password,
+	)
+}
+
+// discoverServices queries the local gNMI server for the enabled management servers
+// and returns their local address indexed by target type.
+func (a *app) discoverServices(ctx context.Context) (map[string]*localService, error) {
+	ctx = withGnmiCredentials(ctx, a.config.username, a.config.password)
+	ctx, cancel := context.WithTimeout(ctx, 2*retryInterval)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, gnmiServerUnixSocket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	rsp, err := gnmi.NewGNMIClient(conn).Get(ctx,
+		&gnmi.GetRequest{
+			Path:     servicesPaths,
+			Type:     gnmi.GetRequest_CONFIG,
+			Encoding: gnmi.Encoding_ASCII,
+		})
+	if err != nil {
+		return nil, err
+	}
+	updates := make([]*gnmi.Update, 0)
+	for _, n := range rsp.GetNotification() {
+		updates = append(updates, n.GetUpdate()...)
+	}
+	return parseServices(updates), nil
+}
+
+// parseServices builds the enabled local services from the leaf updates
+// of the management servers configuration.
+func parseServices(updates []*gnmi.Update) map[string]*localService {
+	// [server][network-instance]
+	instances := make(map[string]map[string]*serviceInstance)
+	// json-rpc-server https instances, [network-instance]
+	httpsInstances := make(map[string]*serviceInstance)
+	unixSockets := make(map[string]bool)
+	for _, u := range updates {
+		elems := u.GetPath().GetElem()
+		if len(elems) < 3 || elems[0].GetName() != "system" {
+			continue
+		}
+		server := elems[1].GetName()
+		if _, ok := serviceServer[server]; !ok {
+			continue
+		}
+		path := utils.GnmiPathToXPath(u.GetPath(), true)
+		val := typedValueString(u.GetVal())
+		if strings.HasSuffix(path, "unix-socket/admin-state") {
+			unixSockets[server] = val == "enable"
+			continue
+		}
+		var ni string
+		for _, e := range elems[2:] {
+			if e.GetName() == "network-instance" {
+				ni = e.GetKey()["name"]
+				break
+			}
+		}
+		if ni == "" {
+			continue
+		}
+		if strings.Contains(path, "/https/") {
+			si, ok := httpsInstances[ni]
+			if !ok {
+				si = new(serviceInstance)
+				httpsInstances[ni] = si
+			}
+			switch {
+			case strings.HasSuffix(path, "https/admin-state"):
+				si.adminState = val
+			case strings.HasSuffix(path, "https/port"):
+				si.port = val
+			}
+			continue
+		}
+		if instances[server] == nil {
+			instances[server] = make(map[string]*serviceInstance)
+		}
+		si, ok := instances[server][ni]
+		if !ok {
+			si = new(serviceInstance)
+			instances[server][ni] = si
+		}
+		switch {
+		case strings.HasSuffix(path, "http/admin-state"):
+			si.adminState = val
+		case strings.HasSuffix(path, "http/port"):
+			si.port = val
+		case strings.HasSuffix(path, "network-instance/admin-state"):
+			si.adminState = val
+		case strings.HasSuffix(path, "network-instance/port"):
+			si.port = val
+		}
+	}
+	// the json-rpc-server http transport takes precedence over https,
+	// whatever the order of the updates
+	for ni, https := range httpsInstances {
+		if https.adminState != "enable" {
+			continue
+		}
+		if instances["json-rpc-server"] == nil {
+			instances["json-rpc-server"] = make(map[string]*serviceInstance)
+		}
+		if http, ok := instances["json-rpc-server"][ni]; ok && http.adminState == "enable" {
+			continue
+		}
+		port := https.port
+		if port == "" {
+			port = defaultJSONRPCHTTPSPort
+		}
+		instances["json-rpc-server"][ni] = &serviceInstance{adminState: https.adminState, port: port}
+	}
+
+	services := make(map[string]*localService)
+	for server, typ := range serviceServer {
+		ls := &localService{Type: typ}
+		nis := make([]string, 0, len(instances[server]))
+		for ni, si := range instances[server] {
+			if si.adminState == "enable" {
+				nis = append(nis, ni)
+			}
+		}
+		sort.Strings(nis)
+		ls.NetworkInstances = nis
+		ls.UnixSocket = unixSockets[server]
+		switch {
+		case typ == targetTypeGNMI && ls.UnixSocket:
+			ls.Address = gnmiServerUnixSocket
+		case len(nis) > 0:
+			ni := nis[0]
+			// prefer the mgmt network instance
+			for _, n := range nis {
+				if n == "mgmt" {
+					ni = n
+					break
+				}
+			}
+			port := instances[server][ni].port
+			if port == "" {
+				port = strings.TrimPrefix(defaultLocalAddresses[typ], "localhost:")
+			}
+			ls.Address = "localhost:" + port
+		default:
+			continue
+		}
+		services[typ] = ls
+	}
+	return services
+}
+
+func typedValueString(tv *gnmi.TypedValue) string {
+	switch v := tv.GetValue().(type) {
+	case *gnmi.TypedValue_StringVal:
+		return v.StringVal
+	case *gnmi.TypedValue_AsciiVal:
+		return v.AsciiVal
+	case *gnmi.TypedValue_JsonIetfVal:
+		return strings.Trim(string(v.JsonIetfVal), "\"")
+	case *gnmi.TypedValue_JsonVal:
+		return strings.Trim(string(v.JsonVal), "\"")
+	case *gnmi.TypedValue_UintVal:
+		return strconv.FormatUint(v.UintVal, 10)
+	case *gnmi.TypedValue_IntVal:
+		return strconv.FormatInt(v.IntVal, 10)
+	}
+	return ""
+}
+
+// localAddress returns the discovered local address for a target type,
+// or its default address if the service was not discovered.
+func (a *app) localAddress(typ string) string {
+	a.config.sm.RLock()
+	defer a.config.sm.RUnlock()
+	if ls, ok := a.config.services[typ]; ok && ls.Address != "" {
+		return ls.Address
+	}
+	return defaultLocalAddresses[typ]
+}
+
+// setServices sets the discovered local services, it returns false if they did not change.
+func (a *app) setServices(services map[string]*localService) bool {
+	a.config.sm.Lock()
+	defer a.config.sm.Unlock()
+	if reflect.DeepEqual(services, a.config.services) {
+		return false
+	}
+	a.config.services = services
+	for typ, ls := range services {
+		log.WithFields(log.Fields{
+			"target-type":       typ,
+			"address":           ls.Address,
+			"network-instances": ls.NetworkInstances,
+		}).Info("discovered service")
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/utils"
+)
+
+// testUpdate returns an ASCII update of the leaf at xpath.
+func testUpdate(t *testing.T, xpath, val string) *gnmi.Update {
+	t.Helper()
+	p, err := utils.ParsePath(xpath)
+	if err != nil {
+		t.Fatalf("failed to parse path %s: %v", xpath, err)
+	}
+	return &gnmi.Update{
+		Path: p,
+		Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_AsciiVal{AsciiVal: val}},
+	}
+}
+
+func TestParseServices(t *testing.T) {
+	type leaf struct{ path, val string }
+	tests := []struct {
+		name    string
+		updates []leaf
+		want    map[string]*localService
+	}{
+		{
+			name: "none",
+			want: map[string]*localService{},
+		},
+		{
+			name: "gnmi unix socket",
+			updates: []leaf{
+				{"/system/gnmi-server/unix-socket/admin-state", "enable"},
+				{"/system/gnmi-server/network-instance[name=mgmt]/admin-state", "enable"},
+				{"/system/gnmi-server/network-instance[name=mgmt]/port", "57400"},
+			},
+			want: map[string]*localService{
+				targetTypeGNMI: {Type: targetTypeGNMI, Address: gnmiServerUnixSocket, NetworkInstances: []string{"mgmt"}, UnixSocket: true},
+			},
+		},
+		{
+			name: "mgmt network instance preferred",
+			updates: []leaf{
+				{"/system/gribi-server/network-instance[name=default]/admin-state", "enable"},
+				{"/system/gribi-server/network-instance[name=default]/port", "1111"},
+				{"/system/gribi-server/network-instance[name=mgmt]/admin-state", "enable"},
+				{"/system/gribi-server/network-instance[name=mgmt]/port", "2222"},
+			},
+			want: map[string]*localService{
+				targetTypeGRIBI: {Type: targetTypeGRIBI, Address: "localhost:2222", NetworkInstances: []string{"default", "mgmt"}},
+			},
+		},
+		{
+			name: "default port and disabled server",
+			updates: []leaf{
+				{"/system/netconf-server/network-instance[name=mgmt]/admin-state", "enable"},
+				{"/system/ssh-server/network-instance[name=mgmt]/admin-state", "disable"},
+			},
+			want: map[string]*localService{
+				targetTypeNETCONF: {Type: targetTypeNETCONF, Address: "localhost:830", NetworkInstances: []string{"mgmt"}},
+			},
+		},
+		{
+			name: "json-rpc http before https",
+			updates: []leaf{
+				{"/system/json-rpc-server/network-instance[name=mgmt]/http/admin-state", "enable"},
+				{"/system/json-rpc-server/network-instance[name=mgmt]/http/port", "8080"},
+				{"/system/json-rpc-server/network-instance[name=mgmt]/https/admin-state", "enable"},
+				{"/system/json-rpc-server/network-instance[name=mgmt]/https/port", "8443"},
+			},
+			want: map[string]*localService{
+				targetTypeJSONRPC: {Type: targetTypeJSONRPC, Address: "localhost:8080", NetworkInstances: []string{"mgmt"}},
+			},
+		},
+		{
+			name: "json-rpc https before http",
+			updates: []leaf{
+				{"/system/json-rpc-server/network-instance[name=mgmt]/https/admin-state", "enable"},
+				{"/system/json-rpc-server/network-instance[name=mgmt]/https/port", "8443"},
+				{"/system/json-rpc-server/network-instance[name=mgmt]/http/admin-state", "enable"},
+				{"/system/json-rpc-server/network-instance[name=mgmt]/http/port", "8080"},
+			},
+			want: map[string]*localService{
+				targetTypeJSONRPC: {Type: targetTypeJSONRPC, Address: "localhost:8080", NetworkInstances: []string{"mgmt"}},
+			},
+		},
+		{
+			name: "json-rpc https only",
+			updates: []leaf{
+				{"/system/json-rpc-server/network-instance[name=mgmt]/http/admin-state", "disable"},
+				{"/system/json-rpc-server/network-instance[name=mgmt]/https/admin-state", "enable"},
+			},
+			want: map[string]*localService{
+				targetTypeJSONRPC: {Type: targetTypeJSONRPC, Address: "localhost:443", NetworkInstances: []string{"mgmt"}},
+			},
+		},
+		{
+			name: "unknown server",
+			updates: []leaf{
+				{"/system/unknown-server/network-instance[name=mgmt]/admin-state", "enable"},
+			},
+			want: map[string]*localService{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updates := make([]*gnmi.Update, 0, len(tt.updates))
+			for _, l := range tt.updates {
+				updates = append(updates, testUpdate(t, l.path, l.val))
+			}
+			got := parseServices(updates)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseServices() = %+v, want %+v", describeServices(got), describeServices(tt.want))
+			}
+		})
+	}
+}
+
+func describeServices(services map[string]*localService) map[string]localService {
+	m := make(map[string]localService, len(services))
+	for typ, ls := range services {
+		m[typ] = *ls
+	}
+	return m
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/openconfig/grpctunnel/tunnel"
+	log "github.com/sirupsen/logrus"
+)
+
+// session close reasons
+const (
+	closeReasonEnded              = "session ended"
+	closeReasonIdleTimeout        = "idle timeout"
+	closeReasonMaxLifetime        = "max lifetime reached"
+	closeReasonTargetDeleted      = "target deleted"
+	closeReasonTargetDisabled     = "target disabled"
+	closeReasonDestinationStopped = "destination stopped"
+	closeReasonAgentShutdown      = "agent shutdown"
+	closeReasonKilled             = "killed by operator"
+)
+
+var lastSessionID atomic.Uint64
+
+// session is a tunnel session towards a local target.
+type session struct {
+	id          uint64
+	tunnel      string
+	destination string
+	// local target name
+	target       string
+	tt           tunnel.Target
+	localAddress string
+	start        time.Time
+	// logger with the session fields set
+	log *log.Entry
+
+	ctx          context.Context
+	cancel       context.CancelFunc
+	lastActivity atomic.Int64
+	// bytes received from and sent to the tunnel
+	rxBytes atomic.Uint64
+	txBytes atomic.Uint64
+
+	m           *sync.Mutex
+	closers     []io.Closer
+	closeReason string
+	end         time.Time
+}
+
+// newSession creates a session of tunnel tn to destination dn,
+// tlog is the tunnel logger.
+func newSession(tlog *log.Entry, tn, dn string, ttd *tunnelTargetDetails, t tunnel.Target) *session {
+	s := &session{
+		id:           lastSessionID.Add(1),
+		tunnel:       tn,
+		destination:  dn,
+		target:       ttd.name,
+		tt:           t,
+		localAddress: ttd.dialAddress,
+		start:        time.Now(),
+		m:            new(sync.Mutex),
+	}
+	if ttd.exec != nil {
+		s.localAddress = "exec:" + ttd.exec.command
+	}
+	s.log = tlog.WithFields(log.Fields{
+		"destination": dn,
+		"target":      s.target,
+		"session":     s.id,
+	})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.touch()
+	return s
+}
+
+// addCloser registers a resource closed with the session.
+func (s *session) addCloser(c io.Closer) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.closers = append(s.closers, c)
+}
+
+// close ends the session, closing the tunnel stream and the local connection.
+// Only the first close reason is recorded.
+func (s *session) close(reason string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.closeReason != "" {
+		return
+	}
+	s.closeReason = reason
+	s.end = time.Now()
+	for _, c := range s.closers {
+		c.Close()
+	}
+	s.cancel()
+}
+
+// closedByAgent returns true if the session was closed
+// by the agent rather than by one of its ends.
+func (s *session) closedByAgent() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.closeReason != "" && s.closeReason != closeReasonEnded
+}
+
+func (s *session) getCloseReason() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.closeReason
+}
+
+func (s *session) touch() {
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// watch closes the session when it has been idle for idleTimeout
+// or has been running for maxLifetime, zero values disable the checks.
+func (s *session) watch(idleTimeout, maxLifetime time.Duration) {
+	if idleTimeout == 0 && maxLifetime == 0 {
+		return
+	}
+	var lifetime <-chan time.Time
+	if maxLifetime > 0 {
+		t := time.NewTimer(maxLifetime)
+		defer t.Stop()
+		lifetime = t.C
+	}
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if idleTimeout > 0 {
+		idleTimer = time.NewTimer(idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-lifetime:
+			s.log.Infof("closing after %s", maxLifetime)
+			s.close(closeReasonMaxLifetime)
+			return
+		case <-idle:
+			idleFor := time.Since(time.Unix(0, s.lastActivity.Load()))
+			if idleFor < idleTimeout {
+				idleTimer.Reset(idleTimeout - idleFor)
+				continue
+			}
+			s.log.Infof("closing after %s idle", idleFor.Round(time.Second))
+			s.close(closeReasonIdleTimeout)
+			return
+		}
+	}
+}
+
+// activityStream wraps a tunnel session stream and records its last activity.
+type activityStream struct {
+	io.ReadWriteCloser
+	s *session
+}
+
+func (as *activityStream) Read(b []byte) (int, error) {
+	n, err := as.ReadWriteCloser.Read(b)
+	if n > 0 {
+		as.s.touch()
+		as.s.rxBytes.Add(uint64(n))
+	}
+	return n, err
+}
+
+func (as *activityStream) Write(b []byte) (int, error) {
+	n, err := as.ReadWriteCloser.Write(b)
+	if n > 0 {
+		as.s.touch()
+		as.s.txBytes.Add(uint64(n))
+	}
+	return n, err
+}
+
+// addSession tracks a new session.
+func (a *app) addSession(s *session) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.sessions[s.id] = s
+	s.log.WithFields(log.Fields{
+		"target-id":     s.tt.ID,
+		"target-type":   s.tt.Type,
+		"local-address": s.localAddress,
+	}).Info("session started")
+}
+
+// endSession closes the session, stops tracking it and records its accounting in the logs and the audit log.
+func (a *app) endSession(s *session) {
+	s.close(closeReasonEnded)
+	a.m.Lock()
+	delete(a.sessions, s.id)
+	a.m.Unlock()
+	s.log.WithFields(log.Fields{
+		"target-id":     s.tt.ID,
+		"target-type":   s.tt.Type,
+		"local-address": s.localAddress,
+		"duration":      s.end.Sub(s.start).Round(time.Millisecond).String(),
+		"rx-bytes":      s.rxBytes.Load(),
+		"tx-bytes":      s.txBytes.Load(),
+		"reason":        s.getCloseReason(),
+	}).Info("session closed")
+	a.auditSession(s)
+}
+
+// closeSessions closes the sessions matching the given function.
+// If grace is not zero, the sessions are given that duration to end on their own
+// before being closed. then, if not nil, is called once all the sessions are closed.
+// It does not block.
+func (a *app) closeSessions(match func(s *session) bool, reason string, grace time.Duration, then func()) {
+	a.m.Lock()
+	sessions := make([]*session, 0)
+	for _, s := range a.sessions {
+		if match(s) {
+			sessions = append(sessions, s)
+		}
+	}
+	a.m.Unlock()
+	if len(sessions) == 0 && then == nil {
+		return
+	}
+	go func() {
+		if grace > 0 && len(sessions) > 0 {
+			log.Infof("draining %d session(s) for %s: %s", len(sessions), grace, reason)
+			deadline := time.After(grace)
+		DRAIN:
+			for _, s := range sessions {
+				select {
+				case <-s.ctx.Done():
+				case <-deadline:
+					break DRAIN
+				}
+			}
+		}
+		for _, s := range sessions {
+			s.close(reason)
+		}
+		if then != nil {
+			then()
+		}
+	}()
+}
+
+// sessionDrainTime returns the drain grace period of the sessions of tunnel tn.
+func (a *app) sessionDrainTime(tn string) time.Duration {
+	if tun, ok := a.config.app.Tunnel[tn]; ok {
+		return time.Duration(tun.Tunnel.SessionDrainTime.Value) * time.Second
+	}
+	return 0
+}
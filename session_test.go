@@ -0,0 +1,270 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openconfig/grpctunnel/tunnel"
+	log "github.com/sirupsen/logrus"
+)
+
+// newTestSession returns a session of tunnel t1, destination d1 and target tg.
+func newTestSession(tg string) *session {
+	ttd := &tunnelTargetDetails{name: tg, ID: "srl1", Type: targetTypeSSH, dialAddress: "localhost:22"}
+	return newSession(log.NewEntry(log.StandardLogger()), "t1", "d1", ttd, tunnel.Target{ID: ttd.ID, Type: ttd.Type})
+}
+
+// testCloser records whether it was closed.
+type testCloser struct{ closed bool }
+
+func (c *testCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestSessionClose(t *testing.T) {
+	tests := []struct {
+		name          string
+		reasons       []string
+		wantReason    string
+		wantByAgent   bool
+		wantCanceled  bool
+		wantCloserRun bool
+	}{
+		{
+			name: "open",
+		},
+		{
+			name:          "ended",
+			reasons:       []string{closeReasonEnded},
+			wantReason:    closeReasonEnded,
+			wantCanceled:  true,
+			wantCloserRun: true,
+		},
+		{
+			name:          "first reason kept",
+			reasons:       []string{closeReasonIdleTimeout, closeReasonEnded},
+			wantReason:    closeReasonIdleTimeout,
+			wantByAgent:   true,
+			wantCanceled:  true,
+			wantCloserRun: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSession("tg1")
+			c := new(testCloser)
+			s.addCloser(c)
+			for _, r := range tt.reasons {
+				s.close(r)
+			}
+			if got := s.getCloseReason(); got != tt.wantReason {
+				t.Errorf("close reason = %q, want %q", got, tt.wantReason)
+			}
+			if got := s.closedByAgent(); got != tt.wantByAgent {
+				t.Errorf("closedByAgent() = %v, want %v", got, tt.wantByAgent)
+			}
+			if got := s.ctx.Err() != nil; got != tt.wantCanceled {
+				t.Errorf("context canceled = %v, want %v", got, tt.wantCanceled)
+			}
+			if c.closed != tt.wantCloserRun {
+				t.Errorf("closer closed = %v, want %v", c.closed, tt.wantCloserRun)
+			}
+			if tt.wantReason != "" && s.end.Before(s.start) {
+				t.Errorf("end %s before start %s", s.end, s.start)
+			}
+		})
+	}
+}
+
+func TestSessionWatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		idleTimeout time.Duration
+		maxLifetime time.Duration
+		// the session is active every activity interval, for activeFor
+		activity  time.Duration
+		activeFor time.Duration
+		// the session is closed by the test after closeAfter if not closed by watch
+		closeAfter time.Duration
+		wantReason string
+		// minimum time before the session is closed
+		wantAfter time.Duration
+	}{
+		{
+			name:       "no limits",
+			closeAfter: 50 * time.Millisecond,
+			wantReason: closeReasonEnded,
+		},
+		{
+			name:        "idle timeout",
+			idleTimeout: 50 * time.Millisecond,
+			closeAfter:  time.Second,
+			wantReason:  closeReasonIdleTimeout,
+			wantAfter:   50 * time.Millisecond,
+		},
+		{
+			name:        "activity delays the idle timeout",
+			idleTimeout: 100 * time.Millisecond,
+			activity:    20 * time.Millisecond,
+			activeFor:   200 * time.Millisecond,
+			closeAfter:  2 * time.Second,
+			wantReason:  closeReasonIdleTimeout,
+			wantAfter:   300 * time.Millisecond,
+		},
+		{
+			name:        "max lifetime despite activity",
+			idleTimeout: 100 * time.Millisecond,
+			maxLifetime: 150 * time.Millisecond,
+			activity:    20 * time.Millisecond,
+			activeFor:   time.Second,
+			closeAfter:  2 * time.Second,
+			wantReason:  closeReasonMaxLifetime,
+			wantAfter:   150 * time.Millisecond,
+		},
+		{
+			name:        "ended before the limits",
+			idleTimeout: time.Second,
+			maxLifetime: time.Second,
+			closeAfter:  50 * time.Millisecond,
+			wantReason:  closeReasonEnded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSession("tg1")
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				s.watch(tt.idleTimeout, tt.maxLifetime)
+			}()
+			if tt.activity > 0 {
+				go func() {
+					tick := time.NewTicker(tt.activity)
+					defer tick.Stop()
+					stop := time.After(tt.activeFor)
+					for {
+						select {
+						case <-s.ctx.Done():
+							return
+						case <-stop:
+							return
+						case <-tick.C:
+							s.touch()
+						}
+					}
+				}()
+			}
+			select {
+			case <-s.ctx.Done():
+			case <-time.After(tt.closeAfter):
+				s.close(closeReasonEnded)
+			}
+			<-done
+			if got := s.getCloseReason(); got != tt.wantReason {
+				t.Errorf("close reason = %q, want %q", got, tt.wantReason)
+			}
+			if d := s.end.Sub(s.start); d < tt.wantAfter {
+				t.Errorf("closed after %s, want at least %s", d, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestActivityStream(t *testing.T) {
+	s := newTestSession("tg1")
+	local, remote := net.Pipe()
+	defer remote.Close()
+	as := &activityStream{ReadWriteCloser: local, s: s}
+	s.lastActivity.Store(0)
+	go func() {
+		remote.Write([]byte("hello"))
+		io.ReadFull(remote, make([]byte, 3))
+	}()
+	if _, err := io.ReadFull(as, make([]byte, 5)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if s.lastActivity.Load() == 0 {
+		t.Error("last activity not updated on read")
+	}
+	s.lastActivity.Store(0)
+	if _, err := as.Write([]byte("bye")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if s.lastActivity.Load() == 0 {
+		t.Error("last activity not updated on write")
+	}
+	if rx, tx := s.rxBytes.Load(), s.txBytes.Load(); rx != 5 || tx != 3 {
+		t.Errorf("rx %d, tx %d bytes, want 5 and 3", rx, tx)
+	}
+}
+
+func TestCloseSessions(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace time.Duration
+		// sessions ending on their own during the grace period
+		ending []string
+		// expected close reason of each session, by target
+		want map[string]string
+	}{
+		{
+			name: "matching sessions closed",
+			want: map[string]string{"tg1": closeReasonTargetDeleted, "tg2": ""},
+		},
+		{
+			name:   "sessions drained",
+			grace:  time.Second,
+			ending: []string{"tg1"},
+			want:   map[string]string{"tg1": closeReasonEnded, "tg2": ""},
+		},
+		{
+			name:  "sessions closed after the grace period",
+			grace: 50 * time.Millisecond,
+			want:  map[string]string{"tg1": closeReasonTargetDeleted, "tg2": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp()
+			sessions := map[string]*session{"tg1": newTestSession("tg1"), "tg2": newTestSession("tg2")}
+			for _, s := range sessions {
+				a.addSession(s)
+			}
+			done := make(chan struct{})
+			a.closeSessions(func(s *session) bool {
+				return s.tunnel == "t1" && s.target == "tg1"
+			}, closeReasonTargetDeleted, tt.grace, func() { close(done) })
+			for _, tg := range tt.ending {
+				a.endSession(sessions[tg])
+			}
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("sessions not closed")
+			}
+			for tg, want := range tt.want {
+				if got := sessions[tg].getCloseReason(); got != want {
+					t.Errorf("session %s close reason = %q, want %q", tg, got, want)
+				}
+			}
+			for _, s := range sessions {
+				a.endSession(s)
+			}
+			if len(a.sessions) != 0 {
+				t.Errorf("%d sessions still tracked", len(a.sessions))
+			}
+		})
+	}
+	// then is called even without a matching session
+	a := newTestApp()
+	done := make(chan struct{})
+	a.closeSessions(func(*session) bool { return true }, closeReasonDestinationStopped, time.Second, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("then not called without sessions")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	"github.com/openconfig/grpctunnel/tunnel"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// maximum time to delete the targets and close the tunnel connections on shutdown.
+const shutdownTimeout = 10 * time.Second
+
+// shutdown deletes all the registered targets from the tunnel servers,
+// closes the tunnel sessions and connections within the shutdown timeout,
+// cancels the agent context by calling cancel, then deletes the agent telemetry
+// and unregisters the agent from NDK.
+// The config handler and the services watcher must be stopped before,
+// they would otherwise register targets while they are deleted.
+func (a *app) shutdown(timeout time.Duration, cancel context.CancelFunc) {
+	deadline := time.Now().Add(timeout)
+	a.m.Lock()
+	clients := make(map[string]map[string]*tunnelDestinationClient, len(a.tunnelClients))
+	for tn, tdcs := range a.tunnelClients {
+		clients[tn] = make(map[string]*tunnelDestinationClient, len(tdcs))
+		for dn, tdc := range tdcs {
+			clients[tn][dn] = tdc
+		}
+	}
+	a.m.Unlock()
+
+	wg := new(sync.WaitGroup)
+	// destinations whose connection is not closed yet, by tunnel
+	pm := new(sync.Mutex)
+	pending := make(map[string]map[string]struct{})
+	for tn, tdcs := range clients {
+		for dn, tdc := range tdcs {
+			// destination not connected yet
+			if tdc.client == nil {
+				continue
+			}
+			for _, ttd := range tdc.allTargets() {
+				tt := tunnel.Target{ID: ttd.ID, Type: ttd.Type}
+				tlog := a.tunnelLog(tn).WithFields(log.Fields{"destination": dn, "target": ttd.name})
+				tlog.Infof("deleting target %+v", tt)
+				err := tdc.client.DeleteTarget(tt)
+				if err != nil {
+					tlog.Errorf("failed to delete target %+v: %v", tt, err)
+				}
+			}
+			// leave time to close the connections once the sessions are drained
+			grace := a.sessionDrainTime(tn)
+			if grace >= timeout {
+				grace = timeout / 2
+			}
+			tn, dn, conn := tn, dn, tdc.conn
+			wg.Add(1)
+			pm.Lock()
+			if pending[tn] == nil {
+				pending[tn] = make(map[string]struct{})
+			}
+			pending[tn][dn] = struct{}{}
+			pm.Unlock()
+			a.closeSessions(func(s *session) bool {
+				return s.tunnel == tn && s.destination == dn
+			}, closeReasonAgentShutdown, grace, func() {
+				defer wg.Done()
+				if conn != nil {
+					conn.Close()
+				}
+				pm.Lock()
+				delete(pending[tn], dn)
+				pm.Unlock()
+				a.tunnelLog(tn).WithField("destination", dn).Info("tunnel connection closed")
+			})
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("all tunnel connections closed")
+	case <-time.After(time.Until(deadline)):
+		pm.Lock()
+		for tn, dns := range pending {
+			for dn := range dns {
+				a.tunnelLog(tn).WithField("destination", dn).Warnf("tunnel connection not closed after %s, continuing shutdown", timeout)
+			}
+		}
+		pm.Unlock()
+	}
+	// stop the tunnel clients and the background loops
+	cancel()
+
+	// a.ctx is canceled at this point,
+	// use a fresh context for the last NDK calls.
+	ctx, cancelNDK := context.WithDeadline(context.Background(), deadline.Add(time.Second))
+	defer cancelNDK()
+	ctx = metadata.AppendToOutgoingContext(ctx, "agent_name", agentName)
+
+	_, err := a.agent.TelemetryServiceClient.TelemetryDelete(ctx, &ndk.TelemetryDeleteRequest{
+		Key: []*ndk.TelemetryKey{{JsPath: grpcTunnelPath}},
+	})
+	if err != nil {
+		log.WithField("path", grpcTunnelPath).Errorf("failed to delete telemetry: %v", err)
+	}
+	alog := log.WithField("agent", agentName)
+	rsp, err := a.agent.SdkMgrServiceClient.AgentUnRegister(ctx, &ndk.AgentRegistrationRequest{})
+	if err != nil {
+		alog.Errorf("failed to unregister agent: %v", err)
+		return
+	}
+	alog.Infof("agent unregistered, status: %s", rsp.GetStatus())
+}
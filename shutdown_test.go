@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	agent "github.com/karimra/srl-ndk-demo"
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	tpb "github.com/openconfig/grpctunnel/proto/tunnel"
+	"github.com/openconfig/grpctunnel/tunnel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// testNDK records the NDK calls made on shutdown.
+type testNDK struct {
+	ndk.SdkMgrServiceClient
+	ndk.SdkMgrTelemetryServiceClient
+	m            sync.Mutex
+	deleted      []string
+	unregistered bool
+}
+
+func (n *testNDK) TelemetryDelete(_ context.Context, req *ndk.TelemetryDeleteRequest, _ ...grpc.CallOption) (*ndk.TelemetryDeleteResponse, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	for _, k := range req.GetKey() {
+		n.deleted = append(n.deleted, k.GetJsPath())
+	}
+	return &ndk.TelemetryDeleteResponse{}, nil
+}
+
+func (n *testNDK) AgentUnRegister(context.Context, *ndk.AgentRegistrationRequest, ...grpc.CallOption) (*ndk.AgentRegistrationResponse, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.unregistered = true
+	return &ndk.AgentRegistrationResponse{}, nil
+}
+
+// startTestTunnel connects a tunnel client to a local tunnel server and registers targets with it.
+// The targets deleted on the server are sent on the returned channel.
+func startTestTunnel(t *testing.T, targets ...tunnel.Target) (*tunnelDestinationClient, <-chan tunnel.Target) {
+	t.Helper()
+	added := make(chan tunnel.Target, 16)
+	deleted := make(chan tunnel.Target, 16)
+	ts, err := tunnel.NewServer(tunnel.ServerConfig{
+		AddTargetHandler:    func(t tunnel.Target) error { added <- t; return nil },
+		DeleteTargetHandler: func(t tunnel.Target) error { deleted <- t; return nil },
+	})
+	if err != nil {
+		t.Fatalf("failed to create tunnel server: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	tpb.RegisterTunnelServer(s, ts)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to dial the tunnel server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client, err := tunnel.NewClient(tpb.NewTunnelClient(conn), tunnel.ClientConfig{
+		RegisterHandler: func(tunnel.Target) error { return nil },
+		Handler:         func(tunnel.Target, io.ReadWriteCloser) error { return nil },
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to create tunnel client: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err = client.Register(ctx); err != nil {
+		t.Fatalf("failed to register the tunnel client: %v", err)
+	}
+	go client.Start(ctx)
+	for _, tt := range targets {
+		if err = client.NewTarget(tt); err != nil {
+			t.Fatalf("failed to register target %+v: %v", tt, err)
+		}
+		select {
+		case <-added:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("target %+v not registered", tt)
+		}
+	}
+	return newTunnelDestinationClient(conn, client, cancel), deleted
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		drainTime uint32
+		timeout   time.Duration
+		// the t1 session ends on its own during the drain time
+		ending     bool
+		wantReason string
+	}{
+		{
+			name:       "sessions closed",
+			timeout:    5 * time.Second,
+			wantReason: closeReasonAgentShutdown,
+		},
+		{
+			name:       "sessions drained",
+			drainTime:  2,
+			timeout:    5 * time.Second,
+			ending:     true,
+			wantReason: closeReasonEnded,
+		},
+		{
+			name:       "drain time capped by the timeout",
+			drainTime:  60,
+			timeout:    200 * time.Millisecond,
+			wantReason: closeReasonAgentShutdown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp()
+			n := new(testNDK)
+			a.agent = &agent.Agent{SdkMgrServiceClient: n, TelemetryServiceClient: n}
+			target := tunnel.Target{ID: "srl1", Type: targetTypeSSH}
+			tdc, deleted := startTestTunnel(t, target)
+			tdc.setTargets("tg1", []*tunnelTargetDetails{{name: "tg1", ID: target.ID, Type: target.Type}})
+			tun := new(tunnelCfg)
+			tun.Tunnel.SessionDrainTime.Value = tt.drainTime
+			a.config.app.Tunnel["t1"] = tun
+			a.tunnelClients["t1"] = map[string]*tunnelDestinationClient{
+				"d1": tdc,
+				// not connected yet
+				"d2": newTunnelDestinationClient(nil, nil, nil),
+			}
+			s1 := newTestSession("tg1")
+			a.addSession(s1)
+			// session of another tunnel
+			s2 := newTestSession("tg1")
+			s2.tunnel = "t2"
+			a.addSession(s2)
+			if tt.ending {
+				go func() {
+					time.Sleep(50 * time.Millisecond)
+					a.endSession(s1)
+				}()
+			}
+			canceled := false
+			start := time.Now()
+			a.shutdown(tt.timeout, func() { canceled = true })
+			if d := time.Since(start); d > tt.timeout+time.Second {
+				t.Errorf("shutdown took %s, want at most %s", d, tt.timeout)
+			}
+			select {
+			case got := <-deleted:
+				if got != target {
+					t.Errorf("deleted target %+v, want %+v", got, target)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("target %+v not deleted", target)
+			}
+			if got := s1.getCloseReason(); got != tt.wantReason {
+				t.Errorf("session close reason = %q, want %q", got, tt.wantReason)
+			}
+			if got := s2.getCloseReason(); got != "" {
+				t.Errorf("other tunnel session closed: %q", got)
+			}
+			if got := tdc.conn.GetState(); got != connectivity.Shutdown {
+				t.Errorf("tunnel connection state = %s, want %s", got, connectivity.Shutdown)
+			}
+			if !canceled {
+				t.Error("agent context not canceled")
+			}
+			n.m.Lock()
+			defer n.m.Unlock()
+			if len(n.deleted) != 1 || n.deleted[0] != grpcTunnelPath || !n.unregistered {
+				t.Errorf("deleted telemetry %v, unregistered %v, want %s deleted and unregistered", n.deleted, n.unregistered, grpcTunnelPath)
+			}
+		})
+	}
+}
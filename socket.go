@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketCfg is the TCP socket configuration of the connections to a destination.
+type socketCfg struct {
+	DSCP            uint32Value  `json:"dscp,omitempty"`
+	TCPKeepalive    *uint32Value `json:"tcp_keepalive,omitempty"`
+	TCPUserTimeout  uint32Value  `json:"tcp_user_timeout,omitempty"`
+	SourceAddress   stringValue  `json:"source_address,omitempty"`
+	SourceInterface stringValue  `json:"source_interface,omitempty"`
+}
+
+// newSocketDialer returns a TCP dialer applying the socket config.
+func newSocketDialer(cfg socketCfg) (*net.Dialer, error) {
+	d := new(net.Dialer)
+	if cfg.TCPKeepalive != nil {
+		d.KeepAlive = time.Duration(cfg.TCPKeepalive.Value) * time.Second
+		if cfg.TCPKeepalive.Value == 0 {
+			// disables the TCP keepalives
+			d.KeepAlive = -1
+		}
+	}
+	if cfg.SourceAddress.Value != "" {
+		laddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(cfg.SourceAddress.Value, "0"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid source address %q: %v", cfg.SourceAddress.Value, err)
+		}
+		d.LocalAddr = laddr
+	}
+	d.Control = socketControl(int(cfg.DSCP.Value), time.Duration(cfg.TCPUserTimeout.Value)*time.Second, cfg.SourceInterface.Value)
+	return d, nil
+}
+
+// socketControl returns a dialer Control function marking the TCP sockets with dscp,
+// setting their TCP user timeout and binding them to interface iface.
+// It returns nil if none is set.
+func socketControl(dscp int, userTimeout time.Duration, iface string) func(network, address string, c syscall.RawConn) error {
+	if dscp == 0 && userTimeout == 0 && iface == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if !strings.HasPrefix(network, "tcp") {
+			return nil
+		}
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			if dscp > 0 {
+				// the DSCP is the 6 most significant bits of the IPv4 TOS and IPv6 traffic class
+				if strings.HasSuffix(network, "6") {
+					serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, dscp<<2)
+				} else {
+					serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, dscp<<2)
+				}
+				if serr != nil {
+					serr = fmt.Errorf("failed to set DSCP %d: %v", dscp, serr)
+					return
+				}
+			}
+			if userTimeout > 0 {
+				serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(userTimeout.Milliseconds()))
+				if serr != nil {
+					serr = fmt.Errorf("failed to set TCP user timeout: %v", serr)
+					return
+				}
+			}
+			if iface != "" {
+				serr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+				if serr != nil {
+					serr = fmt.Errorf("failed to bind to interface %s: %v", iface, serr)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNewSocketDialer(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           string
+		wantKeepAlive time.Duration
+		wantLocalAddr string
+		wantControl   bool
+		wantErr       bool
+	}{
+		{
+			name: "defaults",
+			cfg:  `{}`,
+		},
+		{
+			name:          "tcp keepalive",
+			cfg:           `{"tcp_keepalive":{"value":15}}`,
+			wantKeepAlive: 15 * time.Second,
+		},
+		{
+			name:          "tcp keepalive disabled",
+			cfg:           `{"tcp_keepalive":{"value":0}}`,
+			wantKeepAlive: -1,
+		},
+		{
+			name:          "source address",
+			cfg:           `{"source_address":{"value":"127.0.0.1"}}`,
+			wantLocalAddr: "127.0.0.1:0",
+		},
+		{
+			name:    "invalid source address",
+			cfg:     `{"source_address":{"value":"not-an-address.invalid"}}`,
+			wantErr: true,
+		},
+		{
+			name:        "dscp",
+			cfg:         `{"dscp":{"value":46}}`,
+			wantControl: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := new(destination)
+			if err := json.Unmarshal([]byte(`{"destination":{"socket":`+tt.cfg+`}}`), dest); err != nil {
+				t.Fatalf("failed to decode socket config %s: %v", tt.cfg, err)
+			}
+			d, err := newSocketDialer(dest.Destination.Socket)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newSocketDialer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if d.KeepAlive != tt.wantKeepAlive {
+				t.Errorf("keepalive = %s, want %s", d.KeepAlive, tt.wantKeepAlive)
+			}
+			var laddr string
+			if d.LocalAddr != nil {
+				laddr = d.LocalAddr.String()
+			}
+			if laddr != tt.wantLocalAddr {
+				t.Errorf("local address = %q, want %q", laddr, tt.wantLocalAddr)
+			}
+			if (d.Control != nil) != tt.wantControl {
+				t.Errorf("control set = %v, want %v", d.Control != nil, tt.wantControl)
+			}
+		})
+	}
+}
+
+// sockoptInt returns an integer socket option of conn.
+func sockoptInt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	rc, err := conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		v, serr = unix.GetsockoptInt(int(fd), level, opt)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v
+}
+
+func TestSocketControl(t *testing.T) {
+	if socketControl(0, 0, "") != nil {
+		t.Fatal("socketControl() returned a control function while no option is set")
+	}
+	tests := []struct {
+		name        string
+		network     string
+		dscp        int
+		userTimeout time.Duration
+		iface       string
+	}{
+		{name: "dscp ipv4", network: "tcp4", dscp: 46},
+		{name: "dscp ipv6", network: "tcp6", dscp: 10},
+		{name: "user timeout", network: "tcp4", userTimeout: 20 * time.Second},
+		{name: "dscp and user timeout", network: "tcp4", dscp: 8, userTimeout: time.Second},
+		{name: "interface", network: "tcp4", iface: "lo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := "127.0.0.1"
+			if tt.network == "tcp6" {
+				host = "::1"
+			}
+			l, err := net.Listen(tt.network, net.JoinHostPort(host, "0"))
+			if err != nil {
+				t.Skipf("%s not available: %v", tt.network, err)
+			}
+			defer l.Close()
+			d := &net.Dialer{Control: socketControl(tt.dscp, tt.userTimeout, tt.iface)}
+			conn, err := d.Dial(tt.network, l.Addr().String())
+			if tt.iface != "" && err != nil && errors.Is(err, unix.EPERM) {
+				t.Skipf("binding to an interface requires CAP_NET_RAW: %v", err)
+			}
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer conn.Close()
+			if tt.dscp > 0 {
+				level, opt := unix.IPPROTO_IP, unix.IP_TOS
+				if strings.HasSuffix(tt.network, "6") {
+					level, opt = unix.IPPROTO_IPV6, unix.IPV6_TCLASS
+				}
+				if got := sockoptInt(t, conn, level, opt); got != tt.dscp<<2 {
+					t.Errorf("TOS = %d, want %d", got, tt.dscp<<2)
+				}
+			}
+			if tt.userTimeout > 0 {
+				if got := sockoptInt(t, conn, unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT); got != int(tt.userTimeout.Milliseconds()) {
+					t.Errorf("TCP user timeout = %d, want %d", got, tt.userTimeout.Milliseconds())
+				}
+			}
+		})
+	}
+}
+
+func TestSocketControlNonTCP(t *testing.T) {
+	control := socketControl(46, 0, "")
+	// the options are not applied to the other networks
+	if err := control("udp4", "127.0.0.1:514", nil); err != nil {
+		t.Errorf("control(udp4) error = %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// interval at which the throughput is computed and the statistics telemetry updated
+const statsInterval = 10 * time.Second
+
+// targetStats holds the runtime counters of a tunnel target,
+// shared by all the tunnel destinations the target is registered with.
+type targetStats struct {
+	deniedRPCs       atomic.Uint64
+	rejectedSessions atomic.Uint64
+	// rejected sessions closed without a gRPC status
+	closedRejectedSessions atomic.Uint64
+
+	m              *sync.Mutex
+	activeSessions int
+	limiter        *rate.Limiter
+
+	traffic trafficShapers
+}
+
+type targetStatistics struct {
+	DeniedRPCs       uint64Value `json:"denied_rpcs,omitempty"`
+	ActiveSessions   uint64Value `json:"active_sessions,omitempty"`
+	RejectedSessions uint64Value `json:"rejected_sessions,omitempty"`
+	ClosedRejected   uint64Value `json:"closed_rejected_sessions,omitempty"`
+	trafficStatistics
+}
+
+// tunnelStats holds the runtime counters of a tunnel,
+// shared by all the tunnel destinations and targets.
+type tunnelStats struct {
+	traffic trafficShapers
+}
+
+type uint64Value struct {
+	Value uint64 `json:"value,omitempty"`
+}
+
+func (ts *targetStats) statistics() *targetStatistics {
+	ts.m.Lock()
+	active := ts.activeSessions
+	ts.m.Unlock()
+	return &targetStatistics{
+		DeniedRPCs:        uint64Value{Value: ts.deniedRPCs.Load()},
+		ActiveSessions:    uint64Value{Value: uint64(active)},
+		RejectedSessions:  uint64Value{Value: ts.rejectedSessions.Load()},
+		ClosedRejected:    uint64Value{Value: ts.closedRejectedSessions.Load()},
+		trafficStatistics: ts.traffic.statistics(),
+	}
+}
+
+// clear resets the target counters, the active sessions are not affected.
+func (ts *targetStats) clear() {
+	ts.deniedRPCs.Store(0)
+	ts.rejectedSessions.Store(0)
+	ts.closedRejectedSessions.Store(0)
+	ts.traffic.clear()
+}
+
+// getTargetStats returns the runtime counters of target tg under tunnel tn,
+// creating them if needed.
+func (a *app) getTargetStats(tn, tg string) *targetStats {
+	a.m.Lock()
+	defer a.m.Unlock()
+	if _, ok := a.targetStats[tn]; !ok {
+		a.targetStats[tn] = make(map[string]*targetStats)
+	}
+	ts, ok := a.targetStats[tn][tg]
+	if !ok {
+		ts = &targetStats{m: new(sync.Mutex), traffic: newTrafficShapers()}
+		a.targetStats[tn][tg] = ts
+	}
+	return ts
+}
+
+// getTunnelStats returns the runtime counters of tunnel tn,
+// creating them if needed.
+func (a *app) getTunnelStats(tn string) *tunnelStats {
+	a.m.Lock()
+	defer a.m.Unlock()
+	ts, ok := a.tunnelStats[tn]
+	if !ok {
+		ts = &tunnelStats{traffic: newTrafficShapers()}
+		a.tunnelStats[tn] = ts
+	}
+	return ts
+}
+
+func (a *app) deleteTargetStats(tn, tg string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	delete(a.targetStats[tn], tg)
+}
+
+func (a *app) deleteTunnelStats(tn string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	delete(a.targetStats, tn)
+	delete(a.tunnelStats, tn)
+}
+
+// rpcDenied records an RPC denied by the policy of target tg under tunnel tn.
+func (a *app) rpcDenied(tn, tg, method, reason string) {
+	a.tunnelLog(tn).WithField("target", tg).Warnf("denied RPC %s: %s", method, reason)
+	ts := a.getTargetStats(tn, tg)
+	ts.deniedRPCs.Add(1)
+	a.updateTunnelTargetStatisticsTelemetry(tn, tg, ts)
+}
+
+// sessionRejected records a session from destination dn refused because of the limits of target ttd under tunnel tn,
+// and returns the logger of the rejected session.
+func (a *app) sessionRejected(tn, dn string, ttd *tunnelTargetDetails, reason error) *log.Entry {
+	slog := a.tunnelLog(tn).WithFields(log.Fields{
+		"destination": dn,
+		"target":      ttd.name,
+		"target-id":   ttd.ID,
+		"target-type": ttd.Type,
+	})
+	ts := a.getTargetStats(tn, ttd.name)
+	ts.rejectedSessions.Add(1)
+	if ttd.rejectsWithStatus() {
+		slog.Warnf("rejected session: %v", reason)
+	} else {
+		ts.closedRejectedSessions.Add(1)
+		slog.Warnf("rejected session closed, the target type does not carry gRPC: %v", reason)
+	}
+	a.updateTunnelTargetStatisticsTelemetry(tn, ttd.name, ts)
+	return slog
+}
+
+func (a *app) updateTunnelTargetStatisticsTelemetry(tn, tg string, ts *targetStats) {
+	// auto targets are not part of the configured targets list
+	if strings.HasPrefix(tg, autoTargetPrefix) {
+		return
+	}
+	jsData, err := json.Marshal(ts.statistics())
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	p := fmt.Sprintf("%s{.name==\"%s\"}.target{.name==\"%s\"}.statistics", tunnelPath, tn, tg)
+	a.updateTelemetryPathConfig(p, string(jsData))
+}
+
+func (a *app) updateTunnelStatisticsTelemetry(tn string, ts *tunnelStats) {
+	jsData, err := json.Marshal(ts.traffic.statistics())
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	p := fmt.Sprintf("%s{.name==\"%s\"}.statistics", tunnelPath, tn)
+	a.updateTelemetryPathConfig(p, string(jsData))
+}
+
+// statsLoop periodically computes the tunnels and targets throughput
+// and updates their statistics telemetry.
+func (a *app) statsLoop(ctx context.Context) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.m.Lock()
+			tunnels := make(map[string]*tunnelStats, len(a.tunnelStats))
+			for tn, ts := range a.tunnelStats {
+				tunnels[tn] = ts
+			}
+			targets := make(map[string]map[string]*targetStats, len(a.targetStats))
+			for tn, tgs := range a.targetStats {
+				targets[tn] = make(map[string]*targetStats, len(tgs))
+				for tg, ts := range tgs {
+					targets[tn][tg] = ts
+				}
+			}
+			a.m.Unlock()
+			for tn, ts := range tunnels {
+				ts.traffic.sample(statsInterval)
+				a.updateTunnelStatisticsTelemetry(tn, ts)
+			}
+			for tn, tgs := range targets {
+				for tg, ts := range tgs {
+					ts.traffic.sample(statsInterval)
+					a.updateTunnelTargetStatisticsTelemetry(tn, tg, ts)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestTargetStatistics(t *testing.T) {
+	const statsPath = `.system.grpc_tunnel.tunnel{.name=="t1"}.target{.name=="tg1"}.statistics`
+	tests := []struct {
+		name string
+		run  func(a *app)
+		want targetStatistics
+		// the target statistics telemetry is published
+		wantTelemetry bool
+	}{
+		{
+			name: "no activity",
+			run:  func(a *app) { a.getTargetStats("t1", "tg1") },
+		},
+		{
+			name: "denied RPCs",
+			run: func(a *app) {
+				a.rpcDenied("t1", "tg1", "/gnmi.gNMI/Set", "method not allowed")
+				a.rpcDenied("t1", "tg1", "/gnmi.gNMI/Set", "method not allowed")
+			},
+			want:          targetStatistics{DeniedRPCs: uint64Value{Value: 2}},
+			wantTelemetry: true,
+		},
+		{
+			name: "rejected sessions",
+			run: func(a *app) {
+				reason := errors.New("maximum number of concurrent sessions (1) reached")
+				a.sessionRejected("t1", "d1", &tunnelTargetDetails{name: "tg1", Type: targetTypeGNMI}, reason)
+				a.sessionRejected("t1", "d1", &tunnelTargetDetails{name: "tg1", Type: targetTypeSSH}, reason)
+			},
+			want: targetStatistics{
+				RejectedSessions: uint64Value{Value: 2},
+				ClosedRejected:   uint64Value{Value: 1},
+			},
+			wantTelemetry: true,
+		},
+		{
+			name: "active sessions and traffic",
+			run: func(a *app) {
+				ts := a.getTargetStats("t1", "tg1")
+				ts.acquireSession(sessionLimits{})
+				ts.acquireSession(sessionLimits{})
+				ts.releaseSession()
+				ts.traffic.rx.bytes.Add(100)
+				ts.traffic.tx.bytes.Add(200)
+			},
+			want: targetStatistics{
+				ActiveSessions: uint64Value{Value: 1},
+				trafficStatistics: trafficStatistics{
+					RxBytes: uint64Value{Value: 100},
+					TxBytes: uint64Value{Value: 200},
+				},
+			},
+		},
+		{
+			name: "cleared",
+			run: func(a *app) {
+				ts := a.getTargetStats("t1", "tg1")
+				ts.acquireSession(sessionLimits{})
+				ts.traffic.rx.bytes.Add(100)
+				a.rpcDenied("t1", "tg1", "/gnmi.gNMI/Set", "method not allowed")
+				a.sessionRejected("t1", "d1", &tunnelTargetDetails{name: "tg1", Type: targetTypeSSH}, errors.New("rate limit"))
+				a.clearTunnelTargetStatistics("t1", "tg1")
+			},
+			// the active sessions are not cleared
+			want:          targetStatistics{ActiveSessions: uint64Value{Value: 1}},
+			wantTelemetry: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp()
+			tt.run(a)
+			if got := a.getTargetStats("t1", "tg1").statistics(); *got != tt.want {
+				t.Errorf("statistics() = %+v, want %+v", *got, tt.want)
+			}
+			jsData, ok := a.telemetry.get(statsPath)
+			if ok != tt.wantTelemetry {
+				t.Fatalf("statistics telemetry published = %v, want %v", ok, tt.wantTelemetry)
+			}
+			if !ok {
+				return
+			}
+			got := targetStatistics{}
+			if err := json.Unmarshal([]byte(jsData), &got); err != nil {
+				t.Fatalf("failed to decode the statistics telemetry %s: %v", jsData, err)
+			}
+			if got != tt.want {
+				t.Errorf("statistics telemetry = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoTargetStatisticsTelemetry(t *testing.T) {
+	a := newTestApp()
+	a.rpcDenied("t1", autoTargetPrefix+"gnmi_gnoi", "/gnmi.gNMI/Set", "method not allowed")
+	if got := a.getTargetStats("t1", autoTargetPrefix+"gnmi_gnoi").deniedRPCs.Load(); got != 1 {
+		t.Errorf("denied RPCs = %d, want 1", got)
+	}
+	if paths := a.telemetry.paths(); len(paths) != 0 {
+		t.Errorf("auto target statistics published: %v", paths)
+	}
+}
@@ -10,7 +10,6 @@ import (
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/metadata"
 )
 
 var sysInfoPaths = []*gnmi.Path{
@@ -81,10 +80,7 @@ func createGNMIClient(ctx context.Context) (gnmi.GNMIClient, error) {
 }
 
 func (a *app) getSystemInfo(ctx context.Context) (*systemInfo, error) {
-	ctx = metadata.AppendToOutgoingContext(ctx,
-		"username", a.config.username,
-		"password", a.config.password,
-	)
+	ctx = withGnmiCredentials(ctx, a.config.username, a.config.password)
 	sctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 START:
@@ -4,52 +4,59 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/nokia/srlinux-ndk-go/ndk"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/protobuf/encoding/prototext"
 )
 
+// updateTelemetryPathConfig caches jsData under jsPath and queues its publishing.
 func (a *app) updateTelemetryPathConfig(jsPath string, jsData string) {
-	log.Infof("updating: %s: %s", jsPath, jsData)
-	key := &ndk.TelemetryKey{JsPath: jsPath}
-	data := &ndk.TelemetryData{JsonContent: jsData}
-	info := &ndk.TelemetryInfo{Key: key, Data: data}
+	log.Debugf("updating: %s: %s", jsPath, jsData)
+	a.telemetry.set(jsPath, jsData)
+	a.publisher.update(jsPath)
+}
+
+// deleteTelemetryPath removes jsPath and its descendants from the cache and queues its deletion.
+func (a *app) deleteTelemetryPath(jsPath string) {
+	a.telemetry.remove(jsPath)
+	a.publisher.delete(jsPath)
+}
+
+// publishTelemetry sends infos to NDK in a single TelemetryUpdateRequest.
+func (a *app) publishTelemetry(infos []*ndk.TelemetryInfo) error {
 	telReq := &ndk.TelemetryUpdateRequest{
-		State: []*ndk.TelemetryInfo{info},
+		State: infos,
 	}
-	log.Debugf("Updating telemetry with: %+v", telReq)
-	b, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(telReq)
-	if err != nil {
-		log.Errorf("telemetry request Marshal failed: %+v", err)
-	}
-	fmt.Printf("%s\n", string(b))
+	log.Debugf("updating %d telemetry path(s)", len(infos))
 	r1, err := a.agent.TelemetryServiceClient.TelemetryAddOrUpdate(a.ctx, telReq)
 	if err != nil {
-		log.Errorf("Could not update telemetry key=%s: err=%v", jsPath, err)
-		return
+		return err
 	}
-	log.Infof("Telemetry add/update status: %s, error_string: %q", r1.GetStatus().String(), r1.GetErrorStr())
+	log.Debugf("Telemetry add/update status: %s, error_string: %q", r1.GetStatus().String(), r1.GetErrorStr())
+	if r1.GetStatus() != ndk.SdkMgrStatus_kSdkMgrSuccess {
+		return fmt.Errorf("status=%s: %s", r1.GetStatus(), r1.GetErrorStr())
+	}
+	return nil
 }
 
-func (a *app) deleteTelemetryPath(jsPath string) error {
-	key := &ndk.TelemetryKey{JsPath: jsPath}
-	telReq := &ndk.TelemetryDeleteRequest{}
-	telReq.Key = make([]*ndk.TelemetryKey, 0)
-	telReq.Key = append(telReq.Key, key)
-
-	b, err := prototext.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(telReq)
-	if err != nil {
-		log.Errorf("telemetry request Marshal failed: %+v", err)
+// removeTelemetry deletes jsPaths from NDK in a single TelemetryDeleteRequest.
+func (a *app) removeTelemetry(jsPaths ...string) error {
+	telReq := &ndk.TelemetryDeleteRequest{
+		Key: make([]*ndk.TelemetryKey, 0, len(jsPaths)),
 	}
-	fmt.Printf("%s\n", string(b))
-
+	for _, p := range jsPaths {
+		telReq.Key = append(telReq.Key, &ndk.TelemetryKey{JsPath: p})
+	}
+	log.Debugf("deleting telemetry path(s): %v", jsPaths)
 	r1, err := a.agent.TelemetryServiceClient.TelemetryDelete(a.ctx, telReq)
 	if err != nil {
-		log.Errorf("could not delete telemetry for key : %s", jsPath)
 		return err
 	}
 	log.Debugf("telemetry delete status: %s, error_string: %q", r1.GetStatus().String(), r1.GetErrorStr())
+	if r1.GetStatus() != ndk.SdkMgrStatus_kSdkMgrSuccess {
+		return fmt.Errorf("status=%s: %s", r1.GetStatus(), r1.GetErrorStr())
+	}
 	return nil
 }
 
@@ -61,6 +68,7 @@ func (a *app) updateRootLevelTelemetry(appCfg *appConfig) {
 	}
 
 	a.updateTelemetryPathConfig(grpcTunnelPath, string(jsData))
+	a.recordStateTransition(grpcTunnelPath, stateObject{}, appCfg.OperState, "")
 }
 
 // destination telemetry functions
@@ -91,34 +99,44 @@ func (a *app) updateTunnelTelemetry(name string, dgc *tunnelCfg) {
 	}
 	p := fmt.Sprintf("%s{.name==\"%s\"}", tunnelPath, name)
 	a.updateTelemetryPathConfig(p, string(jsData))
+	a.recordStateTransition(p, stateObject{Tunnel: name}, dgc.Tunnel.OperState, dgc.Tunnel.OperStateDownReason.Value)
 }
 
 func (a *app) deleteTunnelTelemetry(ctx context.Context, name string) {
 	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", tunnelPath, name)
 	log.Infof("Deleting telemetry path %s", jsPath)
 	a.deleteTelemetryPath(jsPath)
+	a.deleteStateHistories(jsPath)
 }
 
 // tunnel handler telemetry functions
 
 func (a *app) updateTunnelTargetTelemetry(tName, hName string, h *target) {
-	jsData, err := json.Marshal(h)
+	// do not expose the gRPC proxy password in the state
+	tg := *h
+	tg.Target.GrpcProxy.Password = stringValue{}
+	jsData, err := json.Marshal(tg)
 	if err != nil {
 		log.Errorf("failed to marshal json data: %v", err)
 		return
 	}
 	p := fmt.Sprintf("%s{.name==\"%s\"}.target{.name==\"%s\"}", tunnelPath, tName, hName)
 	a.updateTelemetryPathConfig(p, string(jsData))
+	a.recordStateTransition(p, stateObject{Tunnel: tName, Target: hName}, h.Target.OperState, h.Target.OperStateDownReason.Value)
 }
 
 func (a *app) deleteTunnelTargetTelemetry(tName, hName string) {
 	jsPath := fmt.Sprintf("%s{.name==\"%s\"}.target{.name==\"%s\"}", tunnelPath, tName, hName)
 	log.Infof("Deleting telemetry path %s", jsPath)
 	a.deleteTelemetryPath(jsPath)
+	a.deleteStateHistories(jsPath)
 }
 
 // tunnel destination telemetry functions
 func (a *app) updateTunnelDestinationTelemetry(tName, dName string, ds *destinationState) {
+	if ds.damper != nil {
+		ds.Dampening = ds.damper.state(time.Now())
+	}
 	jsData, err := json.Marshal(ds)
 	if err != nil {
 		log.Errorf("failed to marshal json data: %v", err)
@@ -126,12 +144,14 @@ func (a *app) updateTunnelDestinationTelemetry(tName, dName string, ds *destinat
 	}
 	p := fmt.Sprintf("%s{.name==\"%s\"}.destination{.name==\"%s\"}", tunnelPath, tName, dName)
 	a.updateTelemetryPathConfig(p, string(jsData))
+	a.recordStateTransition(p, stateObject{Tunnel: tName, Destination: dName}, ds.OperState, ds.OperStateDownReason.Value)
 }
 
 func (a *app) deleteTunnelDestinationTelemetry(tName, dName string) {
 	jsPath := fmt.Sprintf("%s{.name==\"%s\"}.destination{.name==\"%s\"}", tunnelPath, tName, dName)
 	log.Infof("Deleting telemetry path %s", jsPath)
 	a.deleteTelemetryPath(jsPath)
+	a.deleteStateHistories(jsPath)
 }
 
 // tunnel destination target telemetry functions
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	telemetryRetryInterval  = 5 * time.Second
+	telemetryResyncInterval = 5 * time.Minute
+	telemetryPurgeAttempts  = 3
+)
+
+// telemetryCache holds the state published to NDK, by JS path,
+// as well as the telemetry writes that failed and need to be retried.
+type telemetryCache struct {
+	m     *sync.Mutex
+	state map[string]string
+	// JS paths of the failed updates and deletes
+	pendingUpdates map[string]struct{}
+	pendingDeletes map[string]struct{}
+}
+
+func newTelemetryCache() *telemetryCache {
+	return &telemetryCache{
+		m:              new(sync.Mutex),
+		state:          make(map[string]string),
+		pendingUpdates: make(map[string]struct{}),
+		pendingDeletes: make(map[string]struct{}),
+	}
+}
+
+// isTelemetrySubPath returns true if jsPath is equal to, or is a descendant of, parent.
+func isTelemetrySubPath(jsPath, parent string) bool {
+	return jsPath == parent ||
+		strings.HasPrefix(jsPath, parent+".") ||
+		strings.HasPrefix(jsPath, parent+"{")
+}
+
+func (tc *telemetryCache) set(jsPath, jsData string) {
+	tc.m.Lock()
+	defer tc.m.Unlock()
+	tc.state[jsPath] = jsData
+	delete(tc.pendingDeletes, jsPath)
+}
+
+// remove deletes jsPath and its descendants from the cache.
+func (tc *telemetryCache) remove(jsPath string) {
+	tc.m.Lock()
+	defer tc.m.Unlock()
+	for p := range tc.state {
+		if isTelemetrySubPath(p, jsPath) {
+			delete(tc.state, p)
+			delete(tc.pendingUpdates, p)
+		}
+	}
+}
+
+func (tc *telemetryCache) updateFailed(jsPath string) {
+	tc.m.Lock()
+	defer tc.m.Unlock()
+	if _, ok := tc.state[jsPath]; ok {
+		tc.pendingUpdates[jsPath] = struct{}{}
+	}
+}
+
+func (tc *telemetryCache) deleteFailed(jsPath string) {
+	tc.m.Lock()
+	defer tc.m.Unlock()
+	tc.pendingDeletes[jsPath] = struct{}{}
+}
+
+// pending returns the JS paths of the failed updates, sorted, and of the failed deletes.
+// It clears both pending lists.
+func (tc *telemetryCache) pending() ([]string, []string) {
+	tc.m.Lock()
+	defer tc.m.Unlock()
+	updates := make([]string, 0, len(tc.pendingUpdates))
+	for p := range tc.pendingUpdates {
+		if _, ok := tc.state[p]; ok {
+			updates = append(updates, p)
+		}
+	}
+	sort.Strings(updates)
+	deletes := make([]string, 0, len(tc.pendingDeletes))
+	for p := range tc.pendingDeletes {
+		deletes = append(deletes, p)
+	}
+	tc.pendingUpdates = make(map[string]struct{})
+	tc.pendingDeletes = make(map[string]struct{})
+	return updates, deletes
+}
+
+// paths returns the cached JS paths, sorted so that parents come before their descendants.
+func (tc *telemetryCache) paths() []string {
+	tc.m.Lock()
+	defer tc.m.Unlock()
+	paths := make([]string, 0, len(tc.state))
+	for p := range tc.state {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (tc *telemetryCache) get(jsPath string) (string, bool) {
+	tc.m.Lock()
+	defer tc.m.Unlock()
+	d, ok := tc.state[jsPath]
+	return d, ok
+}
+
+// purgeTelemetry deletes any state left under grpcTunnelPath by a previous instance of the agent.
+func (a *app) purgeTelemetry() {
+	for i := 0; i < telemetryPurgeAttempts; i++ {
+		err := a.removeTelemetry(grpcTunnelPath)
+		if err == nil {
+			log.Infof("purged stale telemetry under %s", grpcTunnelPath)
+			return
+		}
+		log.Errorf("failed to purge stale telemetry under %s: %v", grpcTunnelPath, err)
+		time.Sleep(retryInterval)
+	}
+}
+
+// telemetryLoop retries the failed telemetry writes
+// and periodically re-pushes the full cached state.
+func (a *app) telemetryLoop(ctx context.Context) {
+	retryTicker := time.NewTicker(telemetryRetryInterval)
+	defer retryTicker.Stop()
+	resyncTicker := time.NewTicker(telemetryResyncInterval)
+	defer resyncTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retryTicker.C:
+			a.retryTelemetry()
+		case <-resyncTicker.C:
+			a.resyncTelemetry()
+		}
+	}
+}
+
+// retryTelemetry queues the failed telemetry writes for publishing.
+func (a *app) retryTelemetry() {
+	updates, deletes := a.telemetry.pending()
+	if len(updates) == 0 && len(deletes) == 0 {
+		return
+	}
+	log.Infof("retrying %d telemetry update(s) and %d telemetry delete(s)", len(updates), len(deletes))
+	sort.Strings(deletes)
+	for _, p := range deletes {
+		a.publisher.delete(p)
+		// the state cached under p after its deletion failed must be re-published
+		for _, cp := range a.telemetry.paths() {
+			if isTelemetrySubPath(cp, p) {
+				a.publisher.update(cp)
+			}
+		}
+	}
+	for _, p := range updates {
+		a.publisher.update(p)
+	}
+}
+
+// resyncTelemetry queues the full cached state for publishing.
+func (a *app) resyncTelemetry() {
+	paths := a.telemetry.paths()
+	log.Infof("resyncing %d telemetry path(s)", len(paths))
+	for _, p := range paths {
+		a.publisher.update(p)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsTelemetrySubPath(t *testing.T) {
+	tests := []struct {
+		jsPath string
+		parent string
+		want   bool
+	}{
+		{jsPath: ".system.grpc_tunnel", parent: ".system.grpc_tunnel", want: true},
+		{jsPath: ".system.grpc_tunnel.tunnel{.name==\"t1\"}", parent: ".system.grpc_tunnel", want: true},
+		{jsPath: ".system.grpc_tunnel.tunnel{.name==\"t1\"}.destination{.name==\"d1\"}", parent: ".system.grpc_tunnel.tunnel{.name==\"t1\"}", want: true},
+		{jsPath: ".system.grpc_tunnel.tunnel{.name==\"t10\"}", parent: ".system.grpc_tunnel.tunnel{.name==\"t1\"}", want: false},
+		{jsPath: ".system.grpc_tunnel_stats", parent: ".system.grpc_tunnel", want: false},
+		{jsPath: ".system", parent: ".system.grpc_tunnel", want: false},
+	}
+	for _, tt := range tests {
+		if got := isTelemetrySubPath(tt.jsPath, tt.parent); got != tt.want {
+			t.Errorf("isTelemetrySubPath(%s, %s) = %v, want %v", tt.jsPath, tt.parent, got, tt.want)
+		}
+	}
+}
+
+func TestTelemetryCache(t *testing.T) {
+	const (
+		t1   = `.system.grpc_tunnel.tunnel{.name=="t1"}`
+		t1d1 = `.system.grpc_tunnel.tunnel{.name=="t1"}.destination{.name=="d1"}`
+		t10  = `.system.grpc_tunnel.tunnel{.name=="t10"}`
+	)
+	tests := []struct {
+		name string
+		// applied to a cache holding t1, t1d1 and t10
+		run         func(tc *telemetryCache)
+		wantPaths   []string
+		wantUpdates []string
+		wantDeletes []string
+	}{
+		{
+			name:      "cached",
+			run:       func(*telemetryCache) {},
+			wantPaths: []string{t1, t1d1, t10},
+		},
+		{
+			name:      "remove evicts the descendants",
+			run:       func(tc *telemetryCache) { tc.remove(t1) },
+			wantPaths: []string{t10},
+		},
+		{
+			name: "remove evicts the pending updates",
+			run: func(tc *telemetryCache) {
+				tc.updateFailed(t1d1)
+				tc.updateFailed(t10)
+				tc.remove(t1)
+			},
+			wantPaths:   []string{t10},
+			wantUpdates: []string{t10},
+		},
+		{
+			name: "failed updates of cached paths",
+			run: func(tc *telemetryCache) {
+				tc.updateFailed(t10)
+				tc.updateFailed(t1)
+				tc.updateFailed(".system.grpc_tunnel.unknown")
+			},
+			wantPaths:   []string{t1, t1d1, t10},
+			wantUpdates: []string{t1, t10},
+		},
+		{
+			name: "failed delete",
+			run: func(tc *telemetryCache) {
+				tc.remove(t10)
+				tc.deleteFailed(t10)
+			},
+			wantPaths:   []string{t1, t1d1},
+			wantDeletes: []string{t10},
+		},
+		{
+			name: "failed delete superseded by an update",
+			run: func(tc *telemetryCache) {
+				tc.remove(t10)
+				tc.deleteFailed(t10)
+				tc.set(t10, `{}`)
+			},
+			wantPaths: []string{t1, t1d1, t10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTelemetryCache()
+			tc.set(t10, `{"oper_state":"OPER_STATE_up"}`)
+			tc.set(t1d1, `{}`)
+			tc.set(t1, `{}`)
+			tt.run(tc)
+			if got := tc.paths(); !reflect.DeepEqual(got, tt.wantPaths) {
+				t.Errorf("paths() = %v, want %v", got, tt.wantPaths)
+			}
+			updates, deletes := tc.pending()
+			if len(updates) != len(tt.wantUpdates) || len(updates) > 0 && !reflect.DeepEqual(updates, tt.wantUpdates) {
+				t.Errorf("pending() updates = %v, want %v", updates, tt.wantUpdates)
+			}
+			if len(deletes) != len(tt.wantDeletes) || len(deletes) > 0 && !reflect.DeepEqual(deletes, tt.wantDeletes) {
+				t.Errorf("pending() deletes = %v, want %v", deletes, tt.wantDeletes)
+			}
+			// the pending lists are cleared
+			if updates, deletes := tc.pending(); len(updates) != 0 || len(deletes) != 0 {
+				t.Errorf("pending() not cleared: %v, %v", updates, deletes)
+			}
+		})
+	}
+}
+
+func TestRetryTelemetry(t *testing.T) {
+	const (
+		t1   = `.system.grpc_tunnel.tunnel{.name=="t1"}`
+		t1d1 = `.system.grpc_tunnel.tunnel{.name=="t1"}.destination{.name=="d1"}`
+		t2   = `.system.grpc_tunnel.tunnel{.name=="t2"}`
+	)
+	a := newTestApp()
+	a.telemetry.set(t1, `{}`)
+	a.telemetry.set(t2, `{}`)
+	a.telemetry.updateFailed(t2)
+	// the delete of t1 failed, its cached state and descendants are re-published after it
+	a.telemetry.deleteFailed(t1)
+	a.telemetry.set(t1d1, `{}`)
+	a.retryTelemetry()
+	want := []telemetryOp{
+		{typ: telemetryOpDelete, jsPath: t1},
+		{typ: telemetryOpUpdate, jsPath: t1},
+		{typ: telemetryOpUpdate, jsPath: t1d1},
+		{typ: telemetryOpUpdate, jsPath: t2},
+	}
+	if got := a.publisher.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("retryTelemetry() queued %+v, want %+v", got, want)
+	}
+	// nothing left to retry, the resync queues all the cached paths
+	a.retryTelemetry()
+	if got := a.publisher.take(); len(got) != 0 {
+		t.Errorf("retryTelemetry() queued %+v, want none", got)
+	}
+	a.resyncTelemetry()
+	want = []telemetryOp{
+		{typ: telemetryOpUpdate, jsPath: t1},
+		{typ: telemetryOpUpdate, jsPath: t1d1},
+		{typ: telemetryOpUpdate, jsPath: t2},
+	}
+	if got := a.publisher.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("resyncTelemetry() queued %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	log "github.com/sirupsen/logrus"
+)
+
+// tools commands paths
+const (
+	toolsTunnelPath            = ".tools.system.grpc_tunnel.tunnel"
+	toolsTunnelDestinationPath = ".tools.system.grpc_tunnel.tunnel.destination"
+	toolsTunnelTargetPath      = ".tools.system.grpc_tunnel.tunnel.target"
+	toolsSessionPath           = ".tools.system.grpc_tunnel.session"
+)
+
+// tools commands names
+const (
+	toolsClearStatistics = "clear_statistics"
+	toolsReconnect       = "reconnect"
+	toolsReRegister      = "re_register"
+	toolsKill            = "kill"
+)
+
+// toolsCommands returns the names of the commands set in a tools notification,
+// the commands are empty leaves under the given container.
+func toolsCommands(txCfg *ndk.ConfigNotification, container string) map[string]struct{} {
+	cmds := make(map[string]struct{})
+	// ignore the deletion of the tools leaves
+	if txCfg.GetOp() == ndk.SdkMgrOperation_Delete {
+		return cmds
+	}
+	data := make(map[string]map[string]json.RawMessage)
+	err := json.Unmarshal([]byte(txCfg.GetData().GetJson()), &data)
+	if err != nil {
+		log.Errorf("failed to unmarshal path %q tools command %+v", txCfg.GetKey().GetJsPath(), txCfg.GetData())
+		return cmds
+	}
+	for cmd := range data[container] {
+		cmds[cmd] = struct{}{}
+	}
+	return cmds
+}
+
+// ".tools.system.grpc_tunnel.tunnel" handler
+func (a *app) handleToolsTunnel(ctx context.Context, txCfg *ndk.ConfigNotification) {
+	keys := txCfg.GetKey().GetKeys()
+	if len(keys) != 1 {
+		log.Errorf("unexpected number of keys in path %q: %v: %+v", toolsTunnelPath, keys, txCfg)
+		return
+	}
+	tn := keys[0]
+	if _, ok := toolsCommands(txCfg, "tunnel")[toolsClearStatistics]; ok {
+		a.clearTunnelStatistics(tn)
+	}
+}
+
+// ".tools.system.grpc_tunnel.tunnel.destination" handler
+func (a *app) handleToolsTunnelDestination(ctx context.Context, txCfg *ndk.ConfigNotification) {
+	keys := txCfg.GetKey().GetKeys()
+	if len(keys) != 2 {
+		log.Errorf("unexpected number of keys in path %q: %v: %+v", toolsTunnelDestinationPath, keys, txCfg)
+		return
+	}
+	tn := keys[0]
+	dn := keys[1]
+	if _, ok := toolsCommands(txCfg, "destination")[toolsReconnect]; ok {
+		a.reconnectTunnelDestination(ctx, tn, dn)
+	}
+}
+
+// ".tools.system.grpc_tunnel.tunnel.target" handler
+func (a *app) handleToolsTunnelTarget(ctx context.Context, txCfg *ndk.ConfigNotification) {
+	keys := txCfg.GetKey().GetKeys()
+	if len(keys) != 2 {
+		log.Errorf("unexpected number of keys in path %q: %v: %+v", toolsTunnelTargetPath, keys, txCfg)
+		return
+	}
+	tn := keys[0]
+	tg := keys[1]
+	cmds := toolsCommands(txCfg, "target")
+	if _, ok := cmds[toolsReRegister]; ok {
+		a.reRegisterTunnelTarget(ctx, tn, tg)
+	}
+	if _, ok := cmds[toolsClearStatistics]; ok {
+		a.clearTunnelTargetStatistics(tn, tg)
+	}
+}
+
+// ".tools.system.grpc_tunnel.session" handler
+func (a *app) handleToolsSession(ctx context.Context, txCfg *ndk.ConfigNotification) {
+	keys := txCfg.GetKey().GetKeys()
+	if len(keys) != 1 {
+		log.Errorf("unexpected number of keys in path %q: %v: %+v", toolsSessionPath, keys, txCfg)
+		return
+	}
+	id, err := strconv.ParseUint(keys[0], 10, 64)
+	if err != nil {
+		log.Errorf("invalid session id %q: %v", keys[0], err)
+		return
+	}
+	if _, ok := toolsCommands(txCfg, "session")[toolsKill]; ok {
+		a.killSession(id)
+	}
+}
+
+// reconnectTunnelDestination closes the connection to destination dn of tunnel tn,
+// then connects to it again if the tunnel is enabled.
+func (a *app) reconnectTunnelDestination(ctx context.Context, tn, dn string) {
+	tun, ok := a.config.app.Tunnel[tn]
+	if !ok {
+		log.Errorf("reconnect: unknown tunnel %s", tn)
+		return
+	}
+	destState, ok := tun.Tunnel.Destination[dn]
+	if !ok {
+		log.Errorf("reconnect: unknown destination %s under tunnel %s", dn, tn)
+		return
+	}
+	a.tunnelLog(tn).WithField("destination", dn).Info("reconnecting")
+	a.stopDestination(ctx, tn, dn, destState, "reconnecting")
+	if a.config.app.AdminState != adminEnable || tun.Tunnel.AdminState != adminEnable {
+		return
+	}
+	a.startDestination(ctx, tn, dn, tun, destState)
+}
+
+// reRegisterTunnelTarget deletes target tg of tunnel tn from all the connected destinations,
+// then registers it again. The active sessions are not affected.
+func (a *app) reRegisterTunnelTarget(ctx context.Context, tn, tg string) {
+	tun, ok := a.config.app.Tunnel[tn]
+	if !ok {
+		log.Errorf("re-register: unknown tunnel %s", tn)
+		return
+	}
+	targetCfg, ok := tun.Tunnel.Target[tg]
+	if !ok {
+		targetCfg, ok = tun.Tunnel.AutoTarget[tg]
+	}
+	if !ok {
+		log.Errorf("re-register: unknown target %s under tunnel %s", tg, tn)
+		return
+	}
+	if targetCfg.Target.AdminState == adminDisable {
+		log.Errorf("re-register: target %s under tunnel %s is disabled", tg, tn)
+		return
+	}
+	a.tunnelLog(tn).WithField("target", tg).Info("re-registering")
+	for dn, dest := range tun.Tunnel.Destination {
+		a.m.Lock()
+		tdc, ok := a.tunnelClients[tn][dn]
+		a.m.Unlock()
+		if !ok || tdc.client == nil {
+			continue
+		}
+		a.stopTunnelHandlerDestination(ctx, tn, tg, dn, dest, tdc)
+		a.startTunnelHandlerDestination(ctx, tn, tg, targetCfg, dn, dest, tdc.client)
+	}
+}
+
+// clearTunnelStatistics resets the statistics of tunnel tn and of its targets.
+func (a *app) clearTunnelStatistics(tn string) {
+	a.tunnelLog(tn).Info("clearing statistics")
+	ts := a.getTunnelStats(tn)
+	ts.traffic.clear()
+	a.updateTunnelStatisticsTelemetry(tn, ts)
+	a.m.Lock()
+	targets := make(map[string]*targetStats, len(a.targetStats[tn]))
+	for tg, tgs := range a.targetStats[tn] {
+		targets[tg] = tgs
+	}
+	a.m.Unlock()
+	for tg, tgs := range targets {
+		tgs.clear()
+		a.updateTunnelTargetStatisticsTelemetry(tn, tg, tgs)
+	}
+}
+
+// clearTunnelTargetStatistics resets the statistics of target tg of tunnel tn.
+func (a *app) clearTunnelTargetStatistics(tn, tg string) {
+	a.tunnelLog(tn).WithField("target", tg).Info("clearing statistics")
+	ts := a.getTargetStats(tn, tg)
+	ts.clear()
+	a.updateTunnelTargetStatisticsTelemetry(tn, tg, ts)
+}
+
+// killSession closes the active session with the given ID.
+func (a *app) killSession(id uint64) {
+	a.m.Lock()
+	s, ok := a.sessions[id]
+	a.m.Unlock()
+	if !ok {
+		log.Errorf("kill: unknown session %d", id)
+		return
+	}
+	s.log.Info("killing session")
+	s.close(closeReasonKilled)
+}
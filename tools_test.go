@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+)
+
+// testToolsNotification returns a tools notification of the given operation, keys and JSON data.
+func testToolsNotification(op ndk.SdkMgrOperation, jsPath string, keys []string, js string) *ndk.ConfigNotification {
+	return &ndk.ConfigNotification{
+		Op:   op,
+		Key:  &ndk.ConfigKey{JsPath: jsPath, Keys: keys},
+		Data: &ndk.ConfigData{DataType: &ndk.ConfigData_Json{Json: js}},
+	}
+}
+
+func TestToolsCommands(t *testing.T) {
+	tests := []struct {
+		name      string
+		op        ndk.SdkMgrOperation
+		js        string
+		container string
+		want      []string
+	}{
+		{
+			name:      "single command",
+			op:        ndk.SdkMgrOperation_Create,
+			js:        `{"target":{"re_register":{}}}`,
+			container: "target",
+			want:      []string{toolsReRegister},
+		},
+		{
+			name:      "commands",
+			op:        ndk.SdkMgrOperation_Update,
+			js:        `{"target":{"re_register":{},"clear_statistics":{}}}`,
+			container: "target",
+			want:      []string{toolsClearStatistics, toolsReRegister},
+		},
+		{
+			name:      "other container",
+			op:        ndk.SdkMgrOperation_Create,
+			js:        `{"tunnel":{"clear_statistics":{}}}`,
+			container: "target",
+		},
+		{
+			name:      "deleted",
+			op:        ndk.SdkMgrOperation_Delete,
+			js:        `{"target":{"re_register":{}}}`,
+			container: "target",
+		},
+		{
+			name:      "invalid data",
+			op:        ndk.SdkMgrOperation_Create,
+			js:        `{"target":"re_register"}`,
+			container: "target",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds := toolsCommands(testToolsNotification(tt.op, toolsTunnelTargetPath, []string{"t1", "tg1"}, tt.js), tt.container)
+			want := make(map[string]struct{}, len(tt.want))
+			for _, c := range tt.want {
+				want[c] = struct{}{}
+			}
+			if !reflect.DeepEqual(cmds, want) {
+				t.Errorf("toolsCommands() = %v, want %v", cmds, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleToolsTunnelClearStatistics(t *testing.T) {
+	a := newTestApp()
+	ts := a.getTunnelStats("t1")
+	ts.traffic.rx.bytes.Add(100)
+	for _, tg := range []string{"tg1", "tg2"} {
+		a.rpcDenied("t1", tg, "/gnmi.gNMI/Set", "method not allowed")
+	}
+	// another tunnel target
+	a.rpcDenied("t2", "tg1", "/gnmi.gNMI/Set", "method not allowed")
+	a.handleToolsTunnel(context.Background(),
+		testToolsNotification(ndk.SdkMgrOperation_Create, toolsTunnelPath, []string{"t1"}, `{"tunnel":{"clear_statistics":{}}}`))
+	if got := ts.traffic.rx.bytes.Load(); got != 0 {
+		t.Errorf("tunnel rx bytes = %d, want 0", got)
+	}
+	if _, ok := a.telemetry.get(`.system.grpc_tunnel.tunnel{.name=="t1"}.statistics`); !ok {
+		t.Error("tunnel statistics telemetry not published")
+	}
+	for tn, want := range map[string]uint64{"t1": 0, "t2": 1} {
+		if got := a.getTargetStats(tn, "tg1").deniedRPCs.Load(); got != want {
+			t.Errorf("tunnel %s target tg1 denied RPCs = %d, want %d", tn, got, want)
+		}
+	}
+	if got := a.getTargetStats("t1", "tg2").deniedRPCs.Load(); got != 0 {
+		t.Errorf("tunnel t1 target tg2 denied RPCs = %d, want 0", got)
+	}
+}
+
+func TestHandleToolsSession(t *testing.T) {
+	tests := []struct {
+		name string
+		op   ndk.SdkMgrOperation
+		// session ID key, the test session ID if empty
+		key        string
+		js         string
+		wantReason string
+	}{
+		{
+			name:       "kill",
+			op:         ndk.SdkMgrOperation_Create,
+			js:         `{"session":{"kill":{}}}`,
+			wantReason: closeReasonKilled,
+		},
+		{
+			name: "unknown session",
+			op:   ndk.SdkMgrOperation_Create,
+			key:  "0",
+			js:   `{"session":{"kill":{}}}`,
+		},
+		{
+			name: "invalid session ID",
+			op:   ndk.SdkMgrOperation_Create,
+			key:  "s1",
+			js:   `{"session":{"kill":{}}}`,
+		},
+		{
+			name: "deleted",
+			op:   ndk.SdkMgrOperation_Delete,
+			js:   `{"session":{"kill":{}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp()
+			s := newTestSession("tg1")
+			a.addSession(s)
+			defer a.endSession(s)
+			key := tt.key
+			if key == "" {
+				key = strconv.FormatUint(s.id, 10)
+			}
+			a.handleToolsSession(context.Background(), testToolsNotification(tt.op, toolsSessionPath, []string{key}, tt.js))
+			if got := s.getCloseReason(); got != tt.wantReason {
+				t.Errorf("session close reason = %q, want %q", got, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"time"
+
+	"google.golang.org/grpc"
+	// registers the gzip compressor
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+)
+
+// compression enum value enabling gzip, no compression is used otherwise
+const compressionGzip = "COMPRESSION_gzip"
+
+// transportCfg is the gRPC transport tuning of a destination,
+// the gRPC defaults apply to the leaves that are not set.
+type transportCfg struct {
+	Keepalive struct {
+		Time                uint32Value `json:"time,omitempty"`
+		Timeout             uint32Value `json:"timeout,omitempty"`
+		PermitWithoutStream boolValue   `json:"permit_without_stream,omitempty"`
+	} `json:"keepalive,omitempty"`
+	InitialWindowSize           uint32Value `json:"initial_window_size,omitempty"`
+	InitialConnectionWindowSize uint32Value `json:"initial_connection_window_size,omitempty"`
+	MaxMessageSize              uint32Value `json:"max_message_size,omitempty"`
+	Compression                 string      `json:"compression,omitempty"`
+}
+
+// transportParams are the gRPC transport parameters applied to a destination connection,
+// the zero values leave the gRPC defaults.
+type transportParams struct {
+	// nil if keepalive pings are not sent
+	keepalive             *keepalive.ClientParameters
+	initialWindowSize     int32
+	initialConnWindowSize int32
+	maxMessageSize        int
+	// empty if the messages are not compressed
+	compressor string
+}
+
+// newTransportParams returns the gRPC transport parameters of dest.
+func newTransportParams(dest *destination) transportParams {
+	cfg := dest.Destination.Transport
+	tp := transportParams{
+		initialWindowSize:     int32(cfg.InitialWindowSize.Value),
+		initialConnWindowSize: int32(cfg.InitialConnectionWindowSize.Value),
+		maxMessageSize:        int(cfg.MaxMessageSize.Value),
+	}
+	// keepalive pings are sent only if a time is set
+	if cfg.Keepalive.Time.Value > 0 {
+		tp.keepalive = &keepalive.ClientParameters{
+			Time:                time.Duration(cfg.Keepalive.Time.Value) * time.Second,
+			PermitWithoutStream: cfg.Keepalive.PermitWithoutStream.Value,
+		}
+		if cfg.Keepalive.Timeout.Value > 0 {
+			tp.keepalive.Timeout = time.Duration(cfg.Keepalive.Timeout.Value) * time.Second
+		}
+	}
+	if cfg.Compression == compressionGzip {
+		tp.compressor = gzip.Name
+	}
+	return tp
+}
+
+// dialOptions returns the gRPC dial options applying the transport parameters.
+func (tp transportParams) dialOptions() []grpc.DialOption {
+	opts := make([]grpc.DialOption, 0, 4)
+	if tp.keepalive != nil {
+		opts = append(opts, grpc.WithKeepaliveParams(*tp.keepalive))
+	}
+	if tp.initialWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(tp.initialWindowSize))
+	}
+	if tp.initialConnWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(tp.initialConnWindowSize))
+	}
+	callOpts := make([]grpc.CallOption, 0, 3)
+	if tp.maxMessageSize > 0 {
+		callOpts = append(callOpts,
+			grpc.MaxCallRecvMsgSize(tp.maxMessageSize),
+			grpc.MaxCallSendMsgSize(tp.maxMessageSize),
+		)
+	}
+	if tp.compressor != "" {
+		callOpts = append(callOpts, grpc.UseCompressor(tp.compressor))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+	return opts
+}
+
+// transportDialOptions returns the gRPC dial options applying the transport config of dest.
+func transportDialOptions(dest *destination) []grpc.DialOption {
+	return newTransportParams(dest).dialOptions()
+}
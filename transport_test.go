@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// newTestDestination returns a destination with the transport config cfg.
+func newTestDestination(t *testing.T, cfg string) *destination {
+	t.Helper()
+	dest := new(destination)
+	if err := json.Unmarshal([]byte(`{"destination":{"transport":`+cfg+`}}`), dest); err != nil {
+		t.Fatalf("failed to decode transport config %s: %v", cfg, err)
+	}
+	return dest
+}
+
+func TestNewTransportParams(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		want transportParams
+		// expected number of dial options
+		wantOpts int
+	}{
+		{
+			name: "defaults",
+			cfg:  `{}`,
+		},
+		{
+			name: "keepalive timeout without time",
+			cfg:  `{"keepalive":{"timeout":{"value":10}}}`,
+		},
+		{
+			name: "keepalive",
+			cfg:  `{"keepalive":{"time":{"value":30},"timeout":{"value":10},"permit_without_stream":{"value":true}}}`,
+			want: transportParams{
+				keepalive: &keepalive.ClientParameters{Time: 30 * time.Second, Timeout: 10 * time.Second, PermitWithoutStream: true},
+			},
+			wantOpts: 1,
+		},
+		{
+			name: "keepalive without timeout",
+			cfg:  `{"keepalive":{"time":{"value":30}}}`,
+			want: transportParams{
+				keepalive: &keepalive.ClientParameters{Time: 30 * time.Second},
+			},
+			wantOpts: 1,
+		},
+		{
+			name:     "window sizes",
+			cfg:      `{"initial_window_size":{"value":"1048576"},"initial_connection_window_size":{"value":2097152}}`,
+			want:     transportParams{initialWindowSize: 1048576, initialConnWindowSize: 2097152},
+			wantOpts: 2,
+		},
+		{
+			name:     "call options",
+			cfg:      `{"max_message_size":{"value":16777216},"compression":"COMPRESSION_gzip"}`,
+			want:     transportParams{maxMessageSize: 16777216, compressor: gzip.Name},
+			wantOpts: 1,
+		},
+		{
+			name: "no compression",
+			cfg:  `{"compression":"COMPRESSION_none"}`,
+		},
+		{
+			name: "all",
+			cfg:  `{"keepalive":{"time":{"value":30}},"initial_window_size":{"value":65536},"initial_connection_window_size":{"value":65536},"compression":"COMPRESSION_gzip"}`,
+			want: transportParams{
+				keepalive:             &keepalive.ClientParameters{Time: 30 * time.Second},
+				initialWindowSize:     65536,
+				initialConnWindowSize: 65536,
+				compressor:            gzip.Name,
+			},
+			wantOpts: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newTransportParams(newTestDestination(t, tt.cfg))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newTransportParams() = %+v, want %+v", got, tt.want)
+			}
+			if opts := got.dialOptions(); len(opts) != tt.wantOpts {
+				t.Errorf("dialOptions() returned %d options, want %d", len(opts), tt.wantOpts)
+			}
+		})
+	}
+}
+
+type encodingKey struct{}
+
+// encodingStatsHandler records the encoding of the requests in their context.
+type encodingStatsHandler struct{}
+
+func (encodingStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, encodingKey{}, new(string))
+}
+
+func (encodingStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok {
+		*ctx.Value(encodingKey{}).(*string) = h.Compression
+	}
+}
+
+func (encodingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (encodingStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+func requestEncoding(ctx context.Context) string {
+	return *ctx.Value(encodingKey{}).(*string)
+}
+
+// TestTransportDialOptions checks the call options on a connection to a local server.
+func TestTransportDialOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.StatsHandler(encodingStatsHandler{}),
+		grpc.UnknownServiceHandler(func(_ any, ss grpc.ServerStream) error {
+			req := new(frame)
+			if err := ss.RecvMsg(req); err != nil {
+				return err
+			}
+			ss.SetHeader(metadata.Pairs("req-encoding", requestEncoding(ss.Context())))
+			return ss.SendMsg(req)
+		}),
+	)
+	go s.Serve(l)
+	defer s.Stop()
+	tests := []struct {
+		name string
+		cfg  string
+		size int
+		// expected request encoding, empty if not compressed
+		wantEncoding string
+		wantCode     codes.Code
+	}{
+		{
+			name: "defaults",
+			cfg:  `{}`,
+			size: 1024,
+		},
+		{
+			name:         "gzip",
+			cfg:          `{"compression":"COMPRESSION_gzip"}`,
+			size:         1024,
+			wantEncoding: gzip.Name,
+		},
+		{
+			name: "message within max size",
+			cfg:  `{"max_message_size":{"value":2048}}`,
+			size: 1024,
+		},
+		{
+			name:     "message over max size",
+			cfg:      `{"max_message_size":{"value":2048}}`,
+			size:     4096,
+			wantCode: codes.ResourceExhausted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(transportDialOptions(newTestDestination(t, tt.cfg)),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})),
+			)
+			conn, err := grpc.Dial(l.Addr().String(), opts...)
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req, resp := &frame{payload: make([]byte, tt.size)}, new(frame)
+			var header metadata.MD
+			err = conn.Invoke(ctx, "/test.Echo/Echo", req, resp, grpc.Header(&header))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("Invoke() error = %v, want code %s", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if len(resp.payload) != tt.size {
+				t.Errorf("response size = %d, want %d", len(resp.payload), tt.size)
+			}
+			if got := header.Get("req-encoding"); len(got) != 1 || got[0] != tt.wantEncoding {
+				t.Errorf("request encoding = %q, want %q", got, tt.wantEncoding)
+			}
+		})
+	}
+}
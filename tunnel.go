@@ -9,6 +9,7 @@ import (
 	"net"
 	"runtime"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -22,19 +23,93 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 )
 
+// interval between the connection attempts to a destination
+const destinationRetryInterval = 5 * time.Second
+
 type tunnelDestinationClient struct {
 	// gRPC connection towards the tunnel server
 	conn *grpc.ClientConn
 	// the gRPC tunnel client
 	client *tunnel.Client
-	// map of handler to targets
-	targets map[string]*tunnelTargetDetails
+	// stops the connection attempts and the tunnel client
+	cancel context.CancelFunc
+	// protects targets, which the config handler, the services watcher,
+	// the tunnel handler and the admin API access concurrently
+	tm *sync.RWMutex
+	// map of target name to the tunnel targets it registered
+	targets map[string][]*tunnelTargetDetails
+}
+
+func newTunnelDestinationClient(conn *grpc.ClientConn, client *tunnel.Client, cancel context.CancelFunc) *tunnelDestinationClient {
+	return &tunnelDestinationClient{
+		conn:    conn,
+		client:  client,
+		cancel:  cancel,
+		tm:      new(sync.RWMutex),
+		targets: make(map[string][]*tunnelTargetDetails),
+	}
+}
+
+// setTargets sets the tunnel targets registered for target tg.
+func (tdc *tunnelDestinationClient) setTargets(tg string, ttds []*tunnelTargetDetails) {
+	tdc.tm.Lock()
+	defer tdc.tm.Unlock()
+	tdc.targets[tg] = ttds
+}
+
+// removeTargets removes and returns the tunnel targets registered for target tg.
+func (tdc *tunnelDestinationClient) removeTargets(tg string) []*tunnelTargetDetails {
+	tdc.tm.Lock()
+	defer tdc.tm.Unlock()
+	ttds, ok := tdc.targets[tg]
+	if !ok {
+		return nil
+	}
+	delete(tdc.targets, tg)
+	return ttds
+}
+
+// allTargets returns a copy of the tunnel targets registered for all targets.
+func (tdc *tunnelDestinationClient) allTargets() []*tunnelTargetDetails {
+	tdc.tm.RLock()
+	defer tdc.tm.RUnlock()
+	ttds := make([]*tunnelTargetDetails, 0, len(tdc.targets))
+	for _, tts := range tdc.targets {
+		ttds = append(ttds, tts...)
+	}
+	return ttds
+}
+
+// lookupTarget returns the tunnel target registered with the ID and type of t, or nil.
+func (tdc *tunnelDestinationClient) lookupTarget(t tunnel.Target) *tunnelTargetDetails {
+	tdc.tm.RLock()
+	defer tdc.tm.RUnlock()
+	for _, ttds := range tdc.targets {
+		for _, ttd := range ttds {
+			if t.ID == ttd.ID && t.Type == ttd.Type {
+				return ttd
+			}
+		}
+	}
+	return nil
 }
 
 type tunnelTargetDetails struct {
+	// local target name
+	name        string
 	ID          string
 	Type        string
 	dialAddress string
+	// set if the target sessions are handled by a local process
+	exec *execConfig
+	// set if the target sessions are proxied at the gRPC level
+	proxy *grpcProxyConfig
+	// limits applied to new sessions
+	limits sessionLimits
+	// bandwidth limit applied to the sessions, across all the target sessions
+	bandwidth bandwidthLimit
+	// DSCP marking of the TCP connections to the local address, 0 if not set
+	dscp int
 }
 
 func (a *app) startTunnel(ctx context.Context, tn string, tunnelConfig *tunnelCfg) error {
@@ -51,7 +126,8 @@ func (a *app) startTunnel(ctx context.Context, tn string, tunnelConfig *tunnelCf
 			continue
 		}
 	}
-	log.Infof("tunnel=%s destinations=%+v", tn, destinations)
+	tlog := a.tunnelLog(tn)
+	tlog.Infof("destinations=%+v", destinations)
 	if len(destinations) == 0 {
 		tunnelConfig.Tunnel.OperState = operDown
 		tunnelConfig.Tunnel.OperStateDownReason.Value = "no destinations found"
@@ -59,30 +135,72 @@ func (a *app) startTunnel(ctx context.Context, tn string, tunnelConfig *tunnelCf
 		return fmt.Errorf("%v", tunnelConfig.Tunnel.OperStateDownReason.Value)
 	}
 
-	for dn, dest := range destinations {
+	for dn := range destinations {
 		destState, ok := tunnelConfig.Tunnel.Destination[dn]
 		if !ok {
-			log.Errorf("destination %s not found under tunnel.destination", dn)
+			tlog.WithField("destination", dn).Error("destination not found under tunnel.destination")
 			continue
 		}
 		// check if tunnelDestination is already running
 		if destState.OperState == operUp {
-			log.Infof("tunnel %s, destination %s is already oper UP", tn, dn)
+			tlog.WithField("destination", dn).Info("destination is already oper UP")
 			continue
 		}
-		destState.OperState = operStarting
-		a.updateTunnelDestinationTelemetry(tn, dn, destState)
-		// TODO: add entry to tunnelTargets map
-		if a.tunnelClients[tn] == nil {
-			a.tunnelClients[tn] = make(map[string]*tunnelDestinationClient)
-		}
-		a.tunnelClients[tn][dn] = new(tunnelDestinationClient)
-		//
-		go a.startTunnelDestination(ctx, tn, dn, tunnelConfig, dest, destState)
+		a.startDestination(ctx, tn, dn, tunnelConfig, destState)
 	}
 	return nil
 }
 
+// destinationDownReason returns the reason destination dn of a tunnel must not be started,
+// or an empty string if it can be started.
+func (a *app) destinationDownReason(dn string, destState *destinationState) string {
+	if destState.AdminState == adminDisable {
+		return "admin down"
+	}
+	dest, ok := a.config.app.Destination[dn]
+	if !ok {
+		return "destination not found"
+	}
+	if dest.Destination.AdminState == adminDisable {
+		return "destination admin down"
+	}
+	return ""
+}
+
+// startDestination starts the connection to destination dn of tunnel tn,
+// unless the destination is disabled.
+func (a *app) startDestination(ctx context.Context, tn, dn string, tunnelConfig *tunnelCfg, destState *destinationState) {
+	if reason := a.destinationDownReason(dn, destState); reason != "" {
+		destState.OperState = operDown
+		destState.OperStateDownReason.Value = reason
+		a.updateTunnelDestinationTelemetry(tn, dn, destState)
+		return
+	}
+	destState.OperState = operStarting
+	destState.OperStateDownReason.Value = ""
+	a.updateTunnelDestinationTelemetry(tn, dn, destState)
+	dctx, cancel := context.WithCancel(ctx)
+	a.m.Lock()
+	if a.tunnelClients[tn] == nil {
+		a.tunnelClients[tn] = make(map[string]*tunnelDestinationClient)
+	}
+	a.tunnelClients[tn][dn] = newTunnelDestinationClient(nil, nil, cancel)
+	a.m.Unlock()
+	go a.startTunnelDestination(dctx, tn, dn, tunnelConfig, a.config.app.Destination[dn], destState)
+}
+
+// stopDestination stops the connection to destination dn of tunnel tn,
+// and sets its oper-state to down with the given reason.
+func (a *app) stopDestination(ctx context.Context, tn, dn string, destState *destinationState, reason string) {
+	a.stopTunnelDestination(ctx, tn, dn)
+	a.m.Lock()
+	delete(a.tunnelClients[tn], dn)
+	a.m.Unlock()
+	destState.OperState = operDown
+	destState.OperStateDownReason.Value = reason
+	a.updateTunnelDestinationTelemetry(tn, dn, destState)
+}
+
 func (a *app) stopTunnel(ctx context.Context, tn string) {
 	tuns, ok := a.tunnelClients[tn]
 	if !ok {
@@ -102,51 +220,90 @@ func (a *app) stopTunnel(ctx context.Context, tn string) {
 
 func (a *app) tunnelHandlerFunc(tn, dn string) func(t tunnel.Target, i io.ReadWriteCloser) error {
 	return func(t tunnel.Target, i io.ReadWriteCloser) error {
-		var dialAddr string
-		var targets map[string]*tunnelTargetDetails
-		// a.m.RLock()
-		if tun, ok := a.tunnelClients[tn]; ok {
-			if dest, ok := tun[dn]; ok && dest != nil {
-				targets = dest.targets
-			}
-		}
-		// a.m.RUnlock()
-		if len(targets) == 0 {
+		a.m.RLock()
+		tdc := a.tunnelClients[tn][dn]
+		a.m.RUnlock()
+		if tdc == nil {
 			return fmt.Errorf("tunnel=%s, destination=%s: no matching target found %+v", tn, dn, t)
 		}
-		log.Debugf("tunnel=%s, destination=%s, targets=%+v", tn, dn, targets)
-		for _, target := range targets {
-			if t.ID == target.ID && t.Type == target.Type {
-				dialAddr = target.dialAddress
-				break
-			}
-		}
-		if len(dialAddr) == 0 {
-			return fmt.Errorf("not matching dial address found for target: %+v", t)
-		}
-
-		network := "tcp"
-		// change network to "unix" if the dial address starts with "unix://"
-		if strings.HasPrefix(dialAddr, "unix://") {
-			network = "unix"
-			dialAddr = strings.TrimPrefix(dialAddr, "unix://")
+		ttd := tdc.lookupTarget(t)
+		if ttd == nil {
+			return fmt.Errorf("no matching target found for: %+v", t)
 		}
-		log.Infof("dialing network=%s, address=%s for target %+v", network, dialAddr, t)
-		conn, err := net.Dial(network, dialAddr)
-		if err != nil {
-			return fmt.Errorf("failed to dial %s: %v", dialAddr, err)
+		ts := a.getTargetStats(tn, ttd.name)
+		if err := ts.acquireSession(ttd.limits); err != nil {
+			slog := a.sessionRejected(tn, dn, ttd, err)
+			rejectSession(slog, ttd, i, err)
+			return nil
 		}
-		// start bidirectional copy
-		if err = bidi.Copy(i, conn); err != nil {
-			return fmt.Errorf("bidi copy error: %v", err)
+		a.updateTunnelTargetStatisticsTelemetry(tn, ttd.name, ts)
+		defer func() {
+			ts.releaseSession()
+			a.updateTunnelTargetStatisticsTelemetry(tn, ttd.name, ts)
+		}()
+		sess := newSession(a.tunnelLog(tn), tn, dn, ttd, t)
+		// apply the tunnel and target bandwidth limits
+		ts.traffic.setLimit(ttd.bandwidth)
+		i = newShapedStream(sess.ctx, i, a.getTunnelStats(tn).traffic, ts.traffic)
+		i = &activityStream{ReadWriteCloser: i, s: sess}
+		sess.addCloser(i)
+		a.addSession(sess)
+		defer a.endSession(sess)
+		go sess.watch(ttd.limits.idleTimeout, ttd.limits.maxLifetime)
+		err := a.runSession(sess, ttd, t, i)
+		// sessions closed by the agent end with an error that
+		// must not tear down the tunnel client
+		if err != nil && sess.closedByAgent() {
+			sess.log.Debugf("session error: %v", err)
+			return nil
 		}
+		return err
+	}
+}
+
+// runSession bridges a tunnel session to the target local address,
+// or to a local command for exec targets.
+func (a *app) runSession(sess *session, ttd *tunnelTargetDetails, t tunnel.Target, i io.ReadWriteCloser) error {
+	if ttd.exec != nil {
+		execSession(sess.ctx, sess.log, ttd.exec, i)
 		return nil
 	}
+	dialAddr := ttd.dialAddress
+	if len(dialAddr) == 0 {
+		return fmt.Errorf("not matching dial address found for target: %+v", t)
+	}
+	if ttd.proxy != nil {
+		return a.proxySession(sess, ttd, i)
+	}
+
+	network := "tcp"
+	// change network to "unix" if the dial address starts with "unix://"
+	if strings.HasPrefix(dialAddr, "unix://") {
+		network = "unix"
+		dialAddr = strings.TrimPrefix(dialAddr, "unix://")
+	}
+	sess.log.Infof("dialing network=%s, address=%s for target %+v", network, dialAddr, t)
+	dialer := &net.Dialer{Control: socketControl(ttd.dscp, 0, "")}
+	conn, err := dialer.Dial(network, dialAddr)
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %v", dialAddr, err)
+	}
+	sess.addCloser(conn)
+	// start bidirectional copy
+	if err = bidi.Copy(i, conn); err != nil {
+		return fmt.Errorf("bidi copy error: %v", err)
+	}
+	return nil
 }
 
 func (a *app) stopAll(ctx context.Context) {
 	for tn := range a.tunnelClients {
 		a.stopTunnel(ctx, tn)
+		if tun, ok := a.config.app.Tunnel[tn]; ok {
+			tun.Tunnel.OperState = operDown
+			tun.Tunnel.OperStateDownReason.Value = "admin down"
+			a.updateTunnelTelemetry(tn, tun)
+		}
 	}
 	a.tunnelClients = make(map[string]map[string]*tunnelDestinationClient)
 }
@@ -155,7 +312,7 @@ func (a *app) startAll(ctx context.Context) {
 	for tn, tun := range a.config.app.Tunnel {
 		err := a.startTunnel(ctx, tn, tun)
 		if err != nil {
-			log.Errorf("failed to start tunnel %s: %v", tn, err)
+			a.tunnelLog(tn).Errorf("failed to start tunnel: %v", err)
 			tun.Tunnel.OperState = operDown
 			tun.Tunnel.OperStateDownReason = stringValue{
 				Value: fmt.Sprintf("tunnel %s failed: %v", tn, err),
@@ -174,12 +331,21 @@ func (a *app) startTunnelDestination(ctx context.Context, tn, dn string, tunnelC
 		netIns = "mgmt"
 	}
 	netInsName := fmt.Sprintf("srbase-%s", netIns)
+	dlog := a.tunnelLog(tn).WithField("destination", dn)
 	n, err := netns.GetFromName(netInsName)
 	if err != nil {
-		log.Errorf("failed getting NS %q: %v", netInsName, err)
+		dlog.Errorf("failed getting NS %q: %v", netInsName, err)
+		return
+	}
+	dlog.Debugf("got namespace: %+v for %s", n, netInsName)
+	dialer, err := newSocketDialer(dest.Destination.Socket)
+	if err != nil {
+		dlog.Error(err)
+		destState.OperState = operDown
+		destState.OperStateDownReason.Value = err.Error()
+		a.updateTunnelDestinationTelemetry(tn, dn, destState)
 		return
 	}
-	log.Debugf("tunnel %s, destination %s got namespace: %+v for %s", tn, dn, n, netInsName)
 
 	opts := []grpc.DialOption{
 		// grpc.WithTransportCredentials(insecure.NewCredentials()),
@@ -188,10 +354,10 @@ func (a *app) startTunnelDestination(ctx context.Context, tn, dn string, tunnelC
 			runtime.LockOSThread()
 			err = netns.Set(n)
 			if err != nil {
-				log.Infof("failed setting NS to %s: %v", n, err)
+				dlog.Errorf("failed setting NS to %s: %v", n, err)
 				return nil, err
 			}
-			return net.Dial("tcp", addr)
+			return dialer.Dial("tcp", addr)
 		}),
 	}
 	if dest.Destination.NoTLS.Value {
@@ -202,55 +368,123 @@ func (a *app) startTunnelDestination(ctx context.Context, tn, dn string, tunnelC
 		}
 		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	}
+	opts = append(opts, transportDialOptions(dest)...)
 	tunnelServerAddr := fmt.Sprintf("%s:%s", dest.Destination.Address.Value, dest.Destination.Port.Value)
-	log.Infof("tunnel %s dialing destination address: %s", tn, tunnelServerAddr)
+	dlog = dlog.WithField("address", tunnelServerAddr)
+	dlog.Info("dialing destination")
 
 	defer runtime.UnlockOSThread()
-	var conn *grpc.ClientConn
 	for {
+		// hold the destination down while it is suppressed by dampening
+		if !a.waitDampening(ctx, tn, dn, destState) {
+			return
+		}
+		conn := a.dialTunnelDestination(ctx, tn, dn, tunnelServerAddr, opts, destState)
+		if conn == nil {
+			return
+		}
+		err = a.runTunnelDestination(ctx, tn, dn, tunnelConfig, destState, conn)
+		conn.Close()
+		if ctx.Err() != nil {
+			// destination stopped or agent shutting down
+			return
+		}
+		dlog.Errorf("destination stopped: %v", err)
+
+		a.config.m.Lock()
+		tnCfg, ok := a.config.app.Tunnel[tn]
+		downReason := a.destinationDownReason(dn, destState)
+		a.config.m.Unlock()
+		if downReason != "" {
+			return
+		}
+		if ok {
+			if tnCfg.Tunnel.AdminState == adminDisable || a.config.app.AdminState == adminDisable {
+				tunnelConfig.Tunnel.OperState = operDown
+				tunnelConfig.Tunnel.OperStateDownReason.Value = "admin down"
+				a.updateTunnelTelemetry(tn, tnCfg)
+				return
+			}
+		} else {
+			return
+		}
+		tunnelConfig.Tunnel.OperState = operFailed
+		tunnelConfig.Tunnel.OperStateDownReason.Value = err.Error()
+		a.updateTunnelTelemetry(tn, tunnelConfig)
+		// the established connection was lost, reconnect
+		a.destinationFlapped(tn, dn, destState)
+		destState.OperState = operDown
+		destState.OperStateDownReason.Value = err.Error()
+		a.updateTunnelDestinationTelemetry(tn, dn, destState)
+		a.setDestinationTargetsDown(tn, dn, destState, "destination down")
 		select {
 		case <-ctx.Done():
 			return
+		case <-time.After(destinationRetryInterval):
+		}
+	}
+}
+
+// dialTunnelDestination connects to the tunnel server of destination dn until it succeeds,
+// it returns nil if ctx is done.
+func (a *app) dialTunnelDestination(ctx context.Context, tn, dn, tunnelServerAddr string, opts []grpc.DialOption, destState *destinationState) *grpc.ClientConn {
+	dlog := a.tunnelLog(tn).WithFields(log.Fields{"destination": dn, "address": tunnelServerAddr})
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
 		default:
 			gnmiCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			defer cancel()
-			conn, err = grpc.DialContext(gnmiCtx, tunnelServerAddr, opts...)
+			conn, err := grpc.DialContext(gnmiCtx, tunnelServerAddr, opts...)
+			cancel()
 			if err != nil {
-				log.Errorf("tunnel %s failed to connect to destination %s, addr=%s: %v",
-					tn, dn, tunnelServerAddr, err)
+				dlog.Errorf("failed to connect to destination: %v", err)
 				destState.OperState = operDown
 				destState.OperStateDownReason.Value = fmt.Sprintf("failed dial addr=%s: %v", tunnelServerAddr, err)
 				a.updateTunnelDestinationTelemetry(tn, dn, destState)
-				time.Sleep(5 * time.Second)
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(destinationRetryInterval):
+				}
 				continue
 			}
+			return conn
 		}
-		break
 	}
-	defer conn.Close()
+}
+
+// runTunnelDestination registers the tunnel client and its targets over conn,
+// then runs it until it fails or ctx is done.
+func (a *app) runTunnelDestination(ctx context.Context, tn, dn string, tunnelConfig *tunnelCfg, destState *destinationState, conn *grpc.ClientConn) error {
+	dlog := a.tunnelLog(tn).WithField("destination", dn)
 	// update tunnel destination telemetry
 	destState.OperState = operUp
 	destState.OperStateDownReason.Value = ""
 	a.updateTunnelDestinationTelemetry(tn, dn, destState)
+	if tunnelConfig.Tunnel.OperState == operFailed {
+		// the tunnel failed on a previous connection loss
+		tunnelConfig.Tunnel.OperState = operUp
+		tunnelConfig.Tunnel.OperStateDownReason.Value = ""
+		a.updateTunnelTelemetry(tn, tunnelConfig)
+	}
 	//
-	log.Infof("connection to destination %s, addr=%s successful", dn, tunnelServerAddr)
+	dlog.Info("connection to destination successful")
 	// create tunnel client
 	client, err := tunnel.NewClient(tpb.NewTunnelClient(conn), tunnel.ClientConfig{
 		RegisterHandler: func(t tunnel.Target) error { return nil },
 		Handler:         a.tunnelHandlerFunc(tn, dn),
 	}, nil)
 	if err != nil {
-		log.Errorf("tunnel %s failed to create tunnel client: %v", tn, err)
-		return
+		return fmt.Errorf("failed to create tunnel client: %v", err)
 	}
-	log.Infof("tunnel client to destination %s, addr=%s successful", dn, tunnelServerAddr)
+	dlog.Info("tunnel client created")
 	// Register and start listening.
 	err = client.Register(ctx)
 	if err != nil {
-		log.Errorf("tunnel %s failed to register: %v", tn, err)
-		return
+		return fmt.Errorf("failed to register: %v", err)
 	}
-	log.Infof("tunnel client to destination %s, addr=%s registered", dn, tunnelServerAddr)
+	dlog.Info("tunnel client registered")
 	if destState.Target == nil {
 		destState.Target = make(map[string]*targetState)
 	}
@@ -258,59 +492,62 @@ func (a *app) startTunnelDestination(ctx context.Context, tn, dn string, tunnelC
 	if _, ok := a.tunnelClients[tn]; !ok {
 		a.tunnelClients[tn] = make(map[string]*tunnelDestinationClient)
 	}
-	a.tunnelClients[tn][dn] = &tunnelDestinationClient{
-		conn:    conn,
-		client:  client,
-		targets: make(map[string]*tunnelTargetDetails),
+	var cancel context.CancelFunc
+	if tdc, ok := a.tunnelClients[tn][dn]; ok {
+		cancel = tdc.cancel
 	}
+	a.tunnelClients[tn][dn] = newTunnelDestinationClient(conn, client, cancel)
 	a.m.Unlock()
 	if len(tunnelConfig.Tunnel.Target) > 0 {
 		// create targets
-		log.Infof("tunnel %s, destination %s: registering clients", tn, dn)
+		dlog.Info("registering targets")
 		for hn, han := range tunnelConfig.Tunnel.Target {
-			log.Infof("tunnel %s, destination %s: registering client from handler %s", tn, dn, hn)
+			if han.Target.AdminState == adminDisable {
+				continue
+			}
+			dlog.WithField("target", hn).Info("registering target")
 			a.startTunnelHandlerDestination(ctx, tn, hn, han, dn, destState, client)
 		}
 	}
+	for hn, han := range tunnelConfig.Tunnel.AutoTarget {
+		dlog.WithField("target", hn).Info("registering auto target")
+		a.startTunnelHandlerDestination(ctx, tn, hn, han, dn, destState, client)
+	}
 	// blocking call
 	client.Start(ctx)
-	//
-
-	err = client.Error()
-	log.Errorf("tunnel %s destination %s stopped: %v", tn, dn, err)
-
-	a.config.m.Lock()
-	tnCfg, ok := a.config.app.Tunnel[tn]
-	a.config.m.Unlock()
-	if ok {
-		if tnCfg.Tunnel.AdminState == adminDisable || a.config.app.AdminState == adminDisable {
-			tunnelConfig.Tunnel.OperState = operDown
-			tunnelConfig.Tunnel.OperStateDownReason.Value = "admin down"
-			a.updateTunnelTelemetry(tn, tnCfg)
-			return
-		}
-	} else {
-		return
+	if err = client.Error(); err != nil {
+		return err
 	}
-	tunnelConfig.Tunnel.OperState = operFailed
-	tunnelConfig.Tunnel.OperStateDownReason.Value = err.Error()
-	a.updateTunnelTelemetry(tn, tunnelConfig)
+	return fmt.Errorf("tunnel client stopped")
 }
 
 func (a *app) stopTunnelDestination(ctx context.Context, tn, dn string) {
 	if _, ok := a.tunnelClients[tn]; ok {
 		if tdc, ok := a.tunnelClients[tn][dn]; ok {
-			for _, ttd := range tdc.targets {
+			dlog := a.tunnelLog(tn).WithField("destination", dn)
+			for _, ttd := range tdc.allTargets() {
 				tt := tunnel.Target{ID: ttd.ID, Type: ttd.Type}
-				log.Infof("tunnel=%s, destination=%s: deleting target %+v", tn, dn, tt)
+				tlog := dlog.WithField("target", ttd.name)
+				tlog.Infof("deleting target %+v", tt)
 				err := tdc.client.DeleteTarget(tt)
 				if err != nil {
-					log.Errorf("failed to delete target %+v: %v", tt, err)
+					tlog.Errorf("failed to delete target %+v: %v", tt, err)
 				}
-				log.Infof("tunnel=%s, destination=%s: deleting target telemetry %+v", tn, dn, tt)
+				tlog.Debugf("deleting target telemetry %+v", tt)
 				a.deleteTunnelDestinationTargetTelemetry(tn, dn, tt.ID, tt.Type)
 			}
-			tdc.conn.Close()
+			// close the destination sessions, then the connection
+			conn, cancel := tdc.conn, tdc.cancel
+			a.closeSessions(func(s *session) bool {
+				return s.tunnel == tn && s.destination == dn
+			}, closeReasonDestinationStopped, a.sessionDrainTime(tn), func() {
+				if cancel != nil {
+					cancel()
+				}
+				if conn != nil {
+					conn.Close()
+				}
+			})
 		}
 	}
 }
@@ -322,9 +559,10 @@ func (a *app) startTunnelHandlerDestination(ctx context.Context,
 	dn string, destState *destinationState,
 	tunnelClient *tunnel.Client,
 ) {
-	ttd, err := a.newTargetDetails(targetCfg)
+	tlog := a.tunnelLog(tn).WithFields(log.Fields{"destination": dn, "target": tg})
+	ttds, err := a.newTargetDetails(targetCfg)
 	if err != nil {
-		log.Errorf("failed to create a targetDetails, tunnel=%s, handler=%s: %v", tn, tg, err)
+		tlog.Errorf("failed to create a targetDetails: %v", err)
 		return
 	}
 
@@ -332,48 +570,58 @@ func (a *app) startTunnelHandlerDestination(ctx context.Context,
 	ttc, ok := a.tunnelClients[tn][dn]
 	if !ok {
 		a.m.Unlock()
-		log.Errorf("client not found for tunnel=%s, destination=%s, handler=%s", tn, dn, tg)
+		tlog.Error("client not found")
 		return
 	}
 	a.m.Unlock()
-	ttc.targets[tg] = &ttd
-	ts := new(targetState)
-	targetName := fmt.Sprintf("%s:::%s", ttd.ID, ttd.Type)
-	destState.Target[targetName] = ts
-
-	ts.Target.OperState = operStarting
-	a.updateTunnelDestinationTargetTelemetry(tn, dn, ttd.ID, ttd.Type, ts)
-	// register target
-	log.Infof("tunnel=%s, destination=%s, handler=%s: registering target %+v", tn, dn, tg, ttd)
-	err = tunnelClient.NewTarget(tunnel.Target{ID: ttd.ID, Type: ttd.Type})
-	if err != nil {
-		log.Errorf("tunnel %s failed to register target %v in destination %s: %v", tn, ttd, dn, err)
-		ts.Target.OperState = operDown
-		ts.Target.OperStateDownReason.Value = err.Error()
+	tts := make([]*tunnelTargetDetails, 0, len(ttds))
+	for i := range ttds {
+		ttds[i].name = tg
+		tts = append(tts, &ttds[i])
+	}
+	ttc.setTargets(tg, tts)
+	for _, ttd := range tts {
+		ts := new(targetState)
+		targetName := fmt.Sprintf("%s:::%s", ttd.ID, ttd.Type)
+		destState.Target[targetName] = ts
+
+		ts.Target.OperState = operStarting
+		a.updateTunnelDestinationTargetTelemetry(tn, dn, ttd.ID, ttd.Type, ts)
+		// register target
+		tlog.Infof("registering target %+v", ttd)
+		err = tunnelClient.NewTarget(tunnel.Target{ID: ttd.ID, Type: ttd.Type})
+		if err != nil {
+			tlog.Errorf("failed to register target %v: %v", ttd, err)
+			ts.Target.OperState = operDown
+			ts.Target.OperStateDownReason.Value = err.Error()
+			a.updateTunnelDestinationTargetTelemetry(tn, dn, ttd.ID, ttd.Type, ts)
+			continue
+		}
+		tlog.Infof("registered target %+v", ttd)
+		ts.Target.OperState = operUp
+		ts.Target.OperStateDownReason.Value = ""
 		a.updateTunnelDestinationTargetTelemetry(tn, dn, ttd.ID, ttd.Type, ts)
-		return
 	}
-	log.Infof("tunnel=%s, destination=%s, handler=%s: registered target %+v", tn, dn, tg, ttd)
-	ts.Target.OperState = operUp
-	ts.Target.OperStateDownReason.Value = ""
-	a.updateTunnelDestinationTargetTelemetry(tn, dn, ttd.ID, ttd.Type, ts)
 }
 
-// de registers a target (of handler hn) from the server
+// de registers the targets (of handler hn) from the server
 func (a *app) stopTunnelHandlerDestination(ctx context.Context,
 	tn, tg, dn string, dest *destinationState,
 	ttd *tunnelDestinationClient) {
-	if tt, ok := ttd.targets[tg]; ok {
+	for _, tt := range ttd.removeTargets(tg) {
 		err := ttd.client.DeleteTarget(tunnel.Target{ID: tt.ID, Type: tt.Type})
 		if err != nil {
-			log.Errorf("tunnel=%s, destination=%s, handler=%s: failed deleting target: %v", tn, dn, tg, tt)
+			a.tunnelLog(tn).WithFields(log.Fields{"destination": dn, "target": tg}).
+				Errorf("failed deleting target %+v: %v", tt, err)
 		}
 		a.deleteTunnelDestinationTargetTelemetry(tn, dn, tt.ID, tt.Type)
-		delete(ttd.targets, tg)
+		delete(dest.Target, fmt.Sprintf("%s:::%s", tt.ID, tt.Type))
 	}
 }
 
-func (a *app) newTargetDetails(tg *target) (tunnelTargetDetails, error) {
+// newTargetDetails returns the tunnel targets a target config translates to,
+// one per (ID, type) combination.
+func (a *app) newTargetDetails(tg *target) ([]tunnelTargetDetails, error) {
 	for {
 		if a.config.sysInfo.Name == "" {
 			time.Sleep(time.Second / 2)
@@ -381,57 +629,200 @@ func (a *app) newTargetDetails(tg *target) (tunnelTargetDetails, error) {
 		}
 		break
 	}
-	var ttd tunnelTargetDetails
-	// ID
-	switch {
-	case tg.Target.ID.MacAddress != nil && tg.Target.ID.MacAddress.Value:
-		ttd.ID = a.config.sysInfo.ChassisMacAddress
-	case tg.Target.ID.UserAgent != nil && tg.Target.ID.UserAgent.Value:
-		ttd.ID = fmt.Sprintf("%s:nokia-srl:%s:%s",
+	if err := checkTarget(tg); err != nil {
+		return nil, err
+	}
+	ids, err := a.targetIDs(tg.Target.ID)
+	if err != nil {
+		return nil, err
+	}
+	types, err := a.targetTypes(tg)
+	if err != nil {
+		return nil, err
+	}
+	ec := newExecConfig(tg)
+	pc := newGrpcProxyConfig(tg)
+	sl := newSessionLimits(tg)
+	ttds := make([]tunnelTargetDetails, 0, len(ids)*len(types))
+	for _, id := range ids {
+		for _, typ := range types {
+			// only the gRPC types sessions go through the gRPC proxy
+			var tpc *grpcProxyConfig
+			if isGrpcType(typ.Type) {
+				tpc = pc
+			}
+			ttds = append(ttds, tunnelTargetDetails{
+				ID:          id,
+				Type:        typ.Type,
+				dialAddress: typ.dialAddress,
+				exec:        ec,
+				proxy:       tpc,
+				limits:      sl,
+				bandwidth:   newBandwidthLimit(tg.Target.Bandwidth),
+				dscp:        int(tg.Target.DSCP.Value),
+			})
+		}
+	}
+	return ttds, nil
+}
+
+// targetIDs returns the list of IDs configured for a target,
+// defaults to the node name if none is set.
+func (a *app) targetIDs(tid targetID) ([]string, error) {
+	ids := make([]string, 0, 1)
+	if tid.NodeName != nil && tid.NodeName.Value {
+		ids = append(ids, a.config.sysInfo.Name)
+	}
+	if tid.UserAgent != nil && tid.UserAgent.Value {
+		ids = append(ids, fmt.Sprintf("%s:nokia-srl:%s:%s",
 			a.config.sysInfo.Name,
 			a.config.sysInfo.ChassisType,
 			a.config.sysInfo.Version,
-		)
-	case tg.Target.ID.NodeName != nil && tg.Target.ID.NodeName.Value:
-		ttd.ID = a.config.sysInfo.Name
-	case tg.Target.ID.Custom != nil && tg.Target.ID.Custom.Value != "":
-		tpl, err := template.New("customID").Parse(tg.Target.ID.Custom.Value)
-		if err != nil {
-			return ttd, fmt.Errorf("failed to parse template: %w", err)
+		))
+	}
+	if tid.MacAddress != nil && tid.MacAddress.Value {
+		ids = append(ids, a.config.sysInfo.ChassisMacAddress)
+	}
+	for _, c := range tid.Custom {
+		if c.Value == "" {
+			continue
 		}
-		b := new(bytes.Buffer)
-		err = tpl.Execute(b, a.config.sysInfo)
+		id, err := renderTemplate("customID", c.Value, a.config.sysInfo)
 		if err != nil {
-			return ttd, fmt.Errorf("failed to execute template: %w", err)
-		}
-		ttd.ID = b.String()
-	}
-	// Type and DialAddress
-	switch {
-	case tg.Target.Type.GrpcServer != nil && tg.Target.Type.GrpcServer.Value:
-		ttd.Type = "GNMI_GNOI"
-		ttd.dialAddress = gnmiServerUnixSocket
-		if tg.Target.LocalAddress.Value != "" {
-			ttd.dialAddress = tg.Target.LocalAddress.Value
-		}
-	case tg.Target.Type.SSHServer != nil && tg.Target.Type.SSHServer.Value:
-		ttd.Type = "SSH"
-		ttd.dialAddress = "localhost:22"
-		if tg.Target.LocalAddress.Value != "" {
-			ttd.dialAddress = tg.Target.LocalAddress.Value
-		}
-	case tg.Target.Type.Custom != nil && tg.Target.Type.Custom.Value != "":
-		tpl, err := template.New("customType").Parse(tg.Target.Type.Custom.Value)
-		if err != nil {
-			return ttd, fmt.Errorf("failed to parse template: %w", err)
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		ids = append(ids, a.config.sysInfo.Name)
+	}
+	return uniqueStrings(ids), nil
+}
+
+// predefinedTypes returns the predefined types enabled on a target.
+func (tg *target) predefinedTypes() []string {
+	types := make([]string, 0, 1)
+	for _, predefined := range []struct {
+		set *boolValue
+		typ string
+	}{
+		{set: tg.Target.Type.GrpcServer, typ: targetTypeGNMI},
+		{set: tg.Target.Type.SSHServer, typ: targetTypeSSH},
+		{set: tg.Target.Type.GribiServer, typ: targetTypeGRIBI},
+		{set: tg.Target.Type.P4rtServer, typ: targetTypeP4RT},
+		{set: tg.Target.Type.JSONRPCServer, typ: targetTypeJSONRPC},
+		{set: tg.Target.Type.NetconfServer, typ: targetTypeNETCONF},
+	} {
+		if predefined.set != nil && predefined.set.Value {
+			types = append(types, predefined.typ)
 		}
-		b := new(bytes.Buffer)
-		err = tpl.Execute(b, a.config.sysInfo)
+	}
+	return types
+}
+
+// customTypes returns the number of custom types set on a target.
+func (tg *target) customTypes() int {
+	n := 0
+	for _, c := range tg.Target.Type.Custom {
+		if c.Value != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// checkTarget returns an error if the target config is ambiguous,
+// such a target is not registered.
+func checkTarget(tg *target) error {
+	predefined := tg.predefinedTypes()
+	if tg.Target.LocalAddress.Value != "" && len(predefined) > 1 && tg.customTypes() == 0 {
+		return fmt.Errorf("local-address is ambiguous with several predefined types, it applies to the custom types or to a single predefined type")
+	}
+	if err := checkRPCPolicy(tg); err != nil {
+		return err
+	}
+	proxied := tg.Target.GrpcProxy.AdminState == adminEnable || newRPCPolicy(tg) != nil
+	if !proxied {
+		return nil
+	}
+	if tg.Target.Exec.Command.Value != "" {
+		return fmt.Errorf("exec targets do not support grpc-proxy nor rpc-policy")
+	}
+	// the default type is grpc-server
+	if len(predefined) == 0 && tg.customTypes() == 0 {
+		return nil
+	}
+	for _, typ := range predefined {
+		if isGrpcType(typ) {
+			return nil
+		}
+	}
+	return fmt.Errorf("grpc-proxy and rpc-policy require a gRPC type (grpc-server, gribi-server or p4rt-server)")
+}
+
+// targetTypes returns the list of types configured for a target
+// along with their local dial address, defaults to grpc-server if none is set.
+// The local-address applies to the custom types, which have no default address,
+// and to a predefined type if it is the only type of the target.
+func (a *app) targetTypes(tg *target) ([]tunnelTargetDetails, error) {
+	types := make([]tunnelTargetDetails, 0, 1)
+	for _, typ := range tg.predefinedTypes() {
+		types = append(types, tunnelTargetDetails{Type: typ, dialAddress: a.localAddress(typ)})
+	}
+	for _, c := range tg.Target.Type.Custom {
+		if c.Value == "" {
+			continue
+		}
+		typ, err := renderTemplate("customType", c.Value, a.config.sysInfo)
 		if err != nil {
-			return ttd, fmt.Errorf("failed to execute template: %w", err)
+			return nil, err
+		}
+		types = append(types, tunnelTargetDetails{Type: typ})
+	}
+	if len(types) == 0 {
+		types = append(types, tunnelTargetDetails{Type: targetTypeGNMI, dialAddress: a.localAddress(targetTypeGNMI)})
+	}
+	result := make([]tunnelTargetDetails, 0, len(types))
+	seen := make(map[string]struct{})
+	for _, typ := range types {
+		if _, ok := seen[typ.Type]; ok {
+			continue
+		}
+		seen[typ.Type] = struct{}{}
+		result = append(result, typ)
+	}
+	if la := tg.Target.LocalAddress.Value; la != "" {
+		for i := range result {
+			if result[i].dialAddress == "" || len(result) == 1 {
+				result[i].dialAddress = la
+			}
+		}
+	}
+	return result, nil
+}
+
+func renderTemplate(name, text string, data any) (string, error) {
+	tpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+	b := new(bytes.Buffer)
+	err = tpl.Execute(b, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+	return b.String(), nil
+}
+
+func uniqueStrings(ss []string) []string {
+	seen := make(map[string]struct{}, len(ss))
+	result := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if _, ok := seen[s]; ok {
+			continue
 		}
-		ttd.Type = b.String()
-		ttd.dialAddress = tg.Target.LocalAddress.Value
+		seen[s] = struct{}{}
+		result = append(result, s)
 	}
-	return ttd, nil
+	return result
 }
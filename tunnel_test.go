@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var testSysInfo = systemInfo{
+	Name:              "srl1",
+	Version:           "v23.10.1",
+	ChassisType:       "7220 IXR-D2L",
+	ChassisMacAddress: "1A:2B:3C:00:00:00",
+}
+
+func newTestApp() *app {
+	a := newApp(context.Background())
+	a.config.sysInfo = testSysInfo
+	return a
+}
+
+// newTestTarget decodes a target from its NDK JSON config.
+func newTestTarget(t *testing.T, js string) *target {
+	t.Helper()
+	tg := new(target)
+	if err := json.Unmarshal([]byte(js), tg); err != nil {
+		t.Fatalf("failed to decode target %s: %v", js, err)
+	}
+	return tg
+}
+
+func TestTargetIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			target: `{"target":{}}`,
+			want:   []string{"srl1"},
+		},
+		{
+			name:   "node name",
+			target: `{"target":{"id":{"node_name":{"value":true}}}}`,
+			want:   []string{"srl1"},
+		},
+		{
+			name:   "user agent",
+			target: `{"target":{"id":{"user_agent":{"value":true}}}}`,
+			want:   []string{"srl1:nokia-srl:7220 IXR-D2L:v23.10.1"},
+		},
+		{
+			name:   "mac address and custom",
+			target: `{"target":{"id":{"mac_address":{"value":true},"custom":[{"value":"{{.Name}}-custom"},{"value":""}]}}}`,
+			want:   []string{"1A:2B:3C:00:00:00", "srl1-custom"},
+		},
+		{
+			name:   "duplicates",
+			target: `{"target":{"id":{"node_name":{"value":true},"custom":[{"value":"{{.Name}}"}]}}}`,
+			want:   []string{"srl1"},
+		},
+		{
+			name:    "invalid template",
+			target:  `{"target":{"id":{"custom":[{"value":"{{.Name"}]}}}`,
+			wantErr: true,
+		},
+	}
+	a := newTestApp()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := newTestTarget(t, tt.target)
+			got, err := a.targetIDs(tg.Target.ID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("targetIDs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targetIDs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		// type: dial address
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			target: `{"target":{}}`,
+			want:   map[string]string{targetTypeGNMI: gnmiServerUnixSocket},
+		},
+		{
+			name:   "single predefined type with local-address",
+			target: `{"target":{"local_address":{"value":"localhost:2222"},"type":{"ssh_server":{"value":true}}}}`,
+			want:   map[string]string{targetTypeSSH: "localhost:2222"},
+		},
+		{
+			name:   "predefined types",
+			target: `{"target":{"type":{"grpc_server":{"value":true},"netconf_server":{"value":true}}}}`,
+			want: map[string]string{
+				targetTypeGNMI:    gnmiServerUnixSocket,
+				targetTypeNETCONF: "localhost:830",
+			},
+		},
+		{
+			name:   "local-address applies to the custom types",
+			target: `{"target":{"local_address":{"value":"localhost:8080"},"type":{"ssh_server":{"value":true},"custom":[{"value":"{{.Name}}-http"}]}}}`,
+			want: map[string]string{
+				targetTypeSSH: "localhost:22",
+				"srl1-http":   "localhost:8080",
+			},
+		},
+		{
+			name:   "duplicate types",
+			target: `{"target":{"type":{"grpc_server":{"value":true},"custom":[{"value":"GNMI_GNOI"}]}}}`,
+			want:   map[string]string{targetTypeGNMI: gnmiServerUnixSocket},
+		},
+		{
+			name:    "invalid template",
+			target:  `{"target":{"local_address":{"value":"localhost:8080"},"type":{"custom":[{"value":"{{"}]}}}`,
+			wantErr: true,
+		},
+	}
+	a := newTestApp()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tg := newTestTarget(t, tt.target)
+			got, err := a.targetTypes(tg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("targetTypes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			types := make(map[string]string, len(got))
+			for _, ttd := range got {
+				types[ttd.Type] = ttd.dialAddress
+			}
+			if len(types) != len(got) || !reflect.DeepEqual(types, tt.want) {
+				t.Errorf("targetTypes() = %+v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		wantErr bool
+	}{
+		{
+			name:   "default",
+			target: `{"target":{}}`,
+		},
+		{
+			name:    "ambiguous local-address",
+			target:  `{"target":{"local_address":{"value":"localhost:2222"},"type":{"ssh_server":{"value":true},"grpc_server":{"value":true}}}}`,
+			wantErr: true,
+		},
+		{
+			name:   "local-address with a custom type",
+			target: `{"target":{"local_address":{"value":"localhost:2222"},"type":{"ssh_server":{"value":true},"grpc_server":{"value":true},"custom":[{"value":"http"}]}}}`,
+		},
+		{
+			name:   "grpc-proxy on the default type",
+			target: `{"target":{"grpc_proxy":{"admin_state":"ADMIN_STATE_enable"}}}`,
+		},
+		{
+			name:    "grpc-proxy without a gRPC type",
+			target:  `{"target":{"type":{"ssh_server":{"value":true}},"grpc_proxy":{"admin_state":"ADMIN_STATE_enable"}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "exec with rpc-policy",
+			target:  `{"target":{"exec":{"command":{"value":"/bin/cat"}},"rpc_policy":{"deny_method":[{"value":"/gnmi.gNMI/Set"}]}}}`,
+			wantErr: true,
+		},
+		{
+			name:   "exec",
+			target: `{"target":{"exec":{"command":{"value":"/bin/cat"}},"type":{"custom":[{"value":"cat"}]}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTarget(newTestTarget(t, tt.target))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
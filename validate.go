@@ -0,0 +1,428 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sampleSystemInfo is used to render the targets IDs and types
+// when no system information is supplied to the validate command.
+var sampleSystemInfo = systemInfo{
+	Name:                "srl1",
+	Version:             "v23.10.1-218-ga3fc1bea5a",
+	ChassisType:         "7220 IXR-D2",
+	ChassisMacAddress:   "1A:2B:3C:FF:00:00",
+	ChassisCLEICode:     "Sim CLEI",
+	ChassisPartNumber:   "Sim Part No.",
+	ChassisSerialNumber: "Sim Serial No.",
+}
+
+// runValidate implements the validate subcommand:
+// validate [-sysinfo <file>] <file>
+func runValidate(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	sysInfoFile := fs.String("sysinfo", "", "JSON/YAML file with the system information used to render the targets IDs and types")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: validate [-sysinfo <file>] <file>")
+	}
+	a := newApp(nil)
+	a.config.sysInfo = sampleSystemInfo
+	if *sysInfoFile != "" {
+		err := readYAMLorJSON(*sysInfoFile, &a.config.sysInfo)
+		if err != nil {
+			return fmt.Errorf("failed to read system information: %v", err)
+		}
+		if a.config.sysInfo.Name == "" {
+			return fmt.Errorf("system information %s: missing Name", *sysInfoFile)
+		}
+	}
+	var blob any
+	err := readYAMLorJSON(fs.Arg(0), &blob)
+	if err != nil {
+		return err
+	}
+	appCfg, err := parseGrpcTunnelBlob(blob)
+	if err != nil {
+		return err
+	}
+	a.config.app = appCfg
+	errs := a.validateConfig(w)
+	if len(errs) > 0 {
+		fmt.Fprintf(w, "\n%d error(s):\n", len(errs))
+		for _, err := range errs {
+			fmt.Fprintf(w, "  - %v\n", err)
+		}
+		return fmt.Errorf("invalid configuration")
+	}
+	fmt.Fprintln(w, "\nconfiguration is valid")
+	return nil
+}
+
+// readYAMLorJSON decodes file into v, JSON files are decoded as YAML.
+// The decoded value is re encoded as JSON then decoded into v
+// to use v's JSON tags.
+func readYAMLorJSON(file string, v any) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var raw any
+	err = yaml.Unmarshal(b, &raw)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", file, err)
+	}
+	b, err = json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", file, err)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// parseGrpcTunnelBlob converts a /system/grpc-tunnel value, in its gNMI JSON shape,
+// to the application config. The value can be wrapped in a gNMI set request file
+// (updates/replaces list) or in a grpc-tunnel container.
+func parseGrpcTunnelBlob(blob any) (*appConfig, error) {
+	root, ok := blob.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected configuration format, expecting an object")
+	}
+	for _, k := range []string{"updates", "replaces"} {
+		ups, ok := root[k].([]any)
+		if !ok {
+			continue
+		}
+		for _, up := range ups {
+			upm, ok := up.(map[string]any)
+			if !ok {
+				continue
+			}
+			if p, _ := upm["path"].(string); strings.Trim(p, "/") == "system/grpc-tunnel" {
+				if v, ok := upm["value"].(map[string]any); ok {
+					root = v
+					break
+				}
+			}
+		}
+	}
+	if len(root) == 1 {
+		for k, v := range root {
+			v, ok := v.(map[string]any)
+			if ok && (k == "grpc-tunnel" || strings.HasSuffix(k, ":grpc-tunnel")) {
+				root = v
+			}
+		}
+	}
+
+	appCfg := &appConfig{
+		AdminState:  ndkEnum("admin-state", root["admin-state"]),
+		LogLevel:    ndkEnum("log-level", root["log-level"]),
+		Destination: make(map[string]*destination),
+		Tunnel:      make(map[string]*tunnelCfg),
+	}
+	for _, entry := range listEntries(root["destination"]) {
+		name, err := entryName("destination", entry)
+		if err != nil {
+			return nil, err
+		}
+		dest := new(destination)
+		err = decodeNDK("destination", entry, dest)
+		if err != nil {
+			return nil, fmt.Errorf("destination %s: %v", name, err)
+		}
+		appCfg.Destination[name] = dest
+	}
+	for _, entry := range listEntries(root["tunnel"]) {
+		tn, err := entryName("tunnel", entry)
+		if err != nil {
+			return nil, err
+		}
+		tun := new(tunnelCfg)
+		err = decodeNDK("tunnel", entry, tun, "destination", "target")
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %s: %v", tn, err)
+		}
+		tun.Tunnel.Destination = make(map[string]*destinationState)
+		tun.Tunnel.Target = make(map[string]*target)
+		for _, dEntry := range listEntries(entry["destination"]) {
+			dn, err := entryName("tunnel destination", dEntry)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %s: %v", tn, err)
+			}
+			tun.Tunnel.Destination[dn] = &destinationState{
+				AdminState: ndkEnum("admin-state", dEntry["admin-state"]),
+			}
+		}
+		for _, tEntry := range listEntries(entry["target"]) {
+			tg, err := entryName("target", tEntry)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %s: %v", tn, err)
+			}
+			t := new(target)
+			err = decodeNDK("target", tEntry, t)
+			if err != nil {
+				return nil, fmt.Errorf("tunnel %s, target %s: %v", tn, tg, err)
+			}
+			tun.Tunnel.Target[tg] = t
+		}
+		appCfg.Tunnel[tn] = tun
+	}
+	return appCfg, nil
+}
+
+// listEntries returns the entries of a YANG list,
+// which can be a single object if the list has one entry.
+func listEntries(v any) []map[string]any {
+	switch v := v.(type) {
+	case map[string]any:
+		return []map[string]any{v}
+	case []any:
+		entries := make([]map[string]any, 0, len(v))
+		for _, e := range v {
+			if e, ok := e.(map[string]any); ok {
+				entries = append(entries, e)
+			}
+		}
+		return entries
+	}
+	return nil
+}
+
+func entryName(list string, entry map[string]any) (string, error) {
+	name, ok := entry["name"]
+	if !ok || name == nil {
+		return "", fmt.Errorf("%s entry without a name", list)
+	}
+	return fmt.Sprint(name), nil
+}
+
+// decodeNDK converts a list entry from its gNMI JSON shape to the shape
+// of the NDK config notifications and decodes it into v.
+// The key leaf and the child lists (skip) are ignored.
+func decodeNDK(container string, entry map[string]any, v any, skip ...string) error {
+	m := make(map[string]any, len(entry))
+	for k, val := range entry {
+		if k == "name" {
+			continue
+		}
+		skipped := false
+		for _, s := range skip {
+			skipped = skipped || k == s
+		}
+		if skipped {
+			continue
+		}
+		m[k] = val
+	}
+	b, err := json.Marshal(map[string]any{container: toNDK("", m)})
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// toNDK converts a gNMI JSON value to the NDK JSON format:
+// names use underscores, leaves are wrapped in a {"value": ...} object,
+// enums are prefixed, empty leaves are set to true.
+func toNDK(name string, v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, val := range v {
+			// strip the module prefix
+			if i := strings.Index(k, ":"); i >= 0 {
+				k = k[i+1:]
+			}
+			m[strings.ReplaceAll(k, "-", "_")] = toNDK(k, val)
+		}
+		return m
+	case []any:
+		// empty leaf
+		if len(v) == 1 && v[0] == nil {
+			return map[string]any{"value": true}
+		}
+		l := make([]any, 0, len(v))
+		for _, e := range v {
+			l = append(l, toNDK(name, e))
+		}
+		return l
+	case nil:
+		return map[string]any{"value": true}
+	case bool:
+		return map[string]any{"value": v}
+	}
+	if strings.HasSuffix(name, "admin-state") || strings.HasSuffix(name, "log-level") ||
+		strings.HasSuffix(name, "transport") || strings.HasSuffix(name, "compression") {
+		return ndkEnum(name, v)
+	}
+	// numbers are passed as strings, the uint32 leaves accept both
+	return map[string]any{"value": fmt.Sprint(v)}
+}
+
+// ndkEnum returns the NDK value of enum leaf name.
+func ndkEnum(name string, v any) string {
+	if v == nil {
+		return ""
+	}
+	s := fmt.Sprint(v)
+	if i := strings.Index(s, ":"); i >= 0 {
+		s = s[i+1:]
+	}
+	switch {
+	case strings.HasSuffix(name, "admin-state"):
+		return "ADMIN_STATE_" + s
+	case strings.HasSuffix(name, "log-level"):
+		return "LOG_LEVEL_" + s
+	case strings.HasSuffix(name, "transport"):
+		return "TRANSPORT_" + s
+	case strings.HasSuffix(name, "compression"):
+		return "COMPRESSION_" + s
+	}
+	return s
+}
+
+// validateConfig checks the destinations references and prints the targets
+// each tunnel would register with each of its destinations.
+func (a *app) validateConfig(w io.Writer) []error {
+	errs := make([]error, 0)
+	fmt.Fprintf(w, "admin-state: %s\n", stateOrDefault(a.config.app.AdminState, adminDisable))
+
+	dNames := make([]string, 0, len(a.config.app.Destination))
+	for dn := range a.config.app.Destination {
+		dNames = append(dNames, dn)
+	}
+	sort.Strings(dNames)
+	for _, dn := range dNames {
+		dest := a.config.app.Destination[dn].Destination
+		if dest.Address.Value == "" {
+			errs = append(errs, fmt.Errorf("destination %s: missing address", dn))
+		}
+		if _, err := newSocketDialer(dest.Socket); err != nil {
+			errs = append(errs, fmt.Errorf("destination %s: %v", dn, err))
+		}
+		if p, ok := newDampeningParams(a.config.app.Destination[dn]); ok {
+			if err := p.check(); err != nil {
+				errs = append(errs, fmt.Errorf("destination %s: %v", dn, err))
+			}
+		}
+	}
+
+	tNames := make([]string, 0, len(a.config.app.Tunnel))
+	for tn := range a.config.app.Tunnel {
+		tNames = append(tNames, tn)
+	}
+	sort.Strings(tNames)
+	for _, tn := range tNames {
+		tun := a.config.app.Tunnel[tn]
+		fmt.Fprintf(w, "tunnel %s: admin-state %s\n", tn, stateOrDefault(tun.Tunnel.AdminState, adminDisable))
+		if len(tun.Tunnel.Destination) == 0 {
+			errs = append(errs, fmt.Errorf("tunnel %s: no destination", tn))
+		}
+		if tun.Tunnel.AutoTargets.AdminState == adminEnable {
+			fmt.Fprintf(w, "  auto-targets enabled, resolved from the enabled management servers at runtime\n")
+		}
+
+		// resolve the targets once, they are the same for all destinations
+		tgNames := make([]string, 0, len(tun.Tunnel.Target))
+		for tg := range tun.Tunnel.Target {
+			tgNames = append(tgNames, tg)
+		}
+		sort.Strings(tgNames)
+		targets := make(map[string][]tunnelTargetDetails, len(tgNames))
+		for _, tg := range tgNames {
+			ttds, err := a.newTargetDetails(tun.Tunnel.Target[tg])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("tunnel %s, target %s: %v", tn, tg, err))
+				continue
+			}
+			for _, ttd := range ttds {
+				if ttd.dialAddress == "" && ttd.exec == nil {
+					errs = append(errs, fmt.Errorf("tunnel %s, target %s: type %q requires a local-address or an exec command", tn, tg, ttd.Type))
+				}
+			}
+			targets[tg] = ttds
+		}
+
+		dNames := make([]string, 0, len(tun.Tunnel.Destination))
+		for dn := range tun.Tunnel.Destination {
+			dNames = append(dNames, dn)
+		}
+		sort.Strings(dNames)
+		for _, dn := range dNames {
+			destState := tun.Tunnel.Destination[dn]
+			dest, ok := a.config.app.Destination[dn]
+			if !ok {
+				errs = append(errs, fmt.Errorf("tunnel %s: unknown destination %s", tn, dn))
+				continue
+			}
+			fmt.Fprintf(w, "  destination %s: %s\n", dn, describeDestination(dest))
+			if reason := a.destinationDownReason(dn, destState); reason != "" {
+				fmt.Fprintf(w, "    not connected: %s\n", reason)
+				continue
+			}
+			for _, tg := range tgNames {
+				ttds, ok := targets[tg]
+				if !ok {
+					continue
+				}
+				if tun.Tunnel.Target[tg].Target.AdminState == adminDisable {
+					fmt.Fprintf(w, "    target %s: not registered: admin down\n", tg)
+					continue
+				}
+				for _, ttd := range ttds {
+					fmt.Fprintf(w, "    target %s: id=%q, type=%q -> %s\n", tg, ttd.ID, ttd.Type, describeTargetDetails(&ttd))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func describeDestination(dest *destination) string {
+	d := dest.Destination
+	port := d.Port.Value
+	if port == "" {
+		port = "57401"
+	}
+	netIns := d.NetworkInstance.Value
+	if netIns == "" {
+		netIns = "mgmt"
+	}
+	tls := "tls"
+	if d.NoTLS.Value {
+		tls = "no-tls"
+	}
+	return fmt.Sprintf("address=%s:%s, network-instance=%s, %s, admin-state=%s",
+		d.Address.Value, port, netIns, tls, stateOrDefault(d.AdminState, adminEnable))
+}
+
+func describeTargetDetails(ttd *tunnelTargetDetails) string {
+	var sb strings.Builder
+	switch {
+	case ttd.exec != nil:
+		sb.WriteString("exec ")
+		sb.WriteString(strings.Join(append([]string{ttd.exec.command}, ttd.exec.args...), " "))
+	default:
+		sb.WriteString(ttd.dialAddress)
+	}
+	if ttd.proxy != nil {
+		sb.WriteString(" (grpc-proxy)")
+	}
+	return sb.String()
+}
+
+func stateOrDefault(state, def string) string {
+	if state == "" {
+		state = def
+	}
+	return strings.TrimPrefix(state, "ADMIN_STATE_")
+}
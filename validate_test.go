@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// testBlob decodes a JSON document as readYAMLorJSON does.
+func testBlob(t *testing.T, js string) any {
+	t.Helper()
+	var blob any
+	if err := json.Unmarshal([]byte(js), &blob); err != nil {
+		t.Fatalf("failed to decode %s: %v", js, err)
+	}
+	return blob
+}
+
+func TestToNDK(t *testing.T) {
+	tests := []struct {
+		name  string
+		leaf  string
+		value string
+		want  string
+	}{
+		{name: "string", leaf: "address", value: `"172.20.20.2"`, want: `{"value":"172.20.20.2"}`},
+		{name: "number", leaf: "port", value: `57401`, want: `{"value":"57401"}`},
+		{name: "bool", leaf: "no-tls", value: `true`, want: `{"value":true}`},
+		{name: "empty leaf", leaf: "grpc-server", value: `[null]`, want: `{"value":true}`},
+		{name: "admin-state", leaf: "admin-state", value: `"enable"`, want: `"ADMIN_STATE_enable"`},
+		{name: "prefixed enum", leaf: "compression", value: `"srl_nokia-grpc-tunnel:gzip"`, want: `"COMPRESSION_gzip"`},
+		{name: "leaf list", leaf: "custom", value: `["a","b"]`, want: `[{"value":"a"},{"value":"b"}]`},
+		{
+			name:  "container",
+			leaf:  "",
+			value: `{"srl_nokia-grpc-tunnel:grpc-proxy":{"admin-state":"disable","local-tls":true}}`,
+			want:  `{"grpc_proxy":{"admin_state":"ADMIN_STATE_disable","local_tls":{"value":true}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(toNDK(tt.leaf, testBlob(t, tt.value)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(testBlob(t, string(b)), testBlob(t, tt.want)) {
+				t.Errorf("toNDK(%q, %s) = %s, want %s", tt.leaf, tt.value, b, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGrpcTunnelBlob(t *testing.T) {
+	const grpcTunnel = `{
+		"admin-state": "enable",
+		"destination": {"name": "d1", "address": "172.20.20.2", "port": 57401, "no-tls": true},
+		"tunnel": [{
+			"name": "t1",
+			"admin-state": "enable",
+			"destination": [{"name": "d1"}],
+			"target": [{"name": "tg1", "type": {"ssh-server": [null]}, "local-address": "localhost:22"}]
+		}]
+	}`
+	tests := []struct {
+		name    string
+		blob    string
+		wantErr bool
+	}{
+		{name: "value", blob: grpcTunnel},
+		{name: "container", blob: `{"srl_nokia-grpc-tunnel:grpc-tunnel":` + grpcTunnel + `}`},
+		{name: "set request file", blob: `{"updates":[{"path":"/system/grpc-tunnel","value":` + grpcTunnel + `}]}`},
+		{name: "not an object", blob: `[]`, wantErr: true},
+		{name: "destination without name", blob: `{"destination":[{"address":"172.20.20.2"}]}`, wantErr: true},
+		{name: "target without name", blob: `{"tunnel":{"name":"t1","target":{"local-address":"localhost:22"}}}`, wantErr: true},
+		{name: "invalid number", blob: `{"destination":{"name":"d1","socket":{"dscp":"af11"}}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appCfg, err := parseGrpcTunnelBlob(testBlob(t, tt.blob))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseGrpcTunnelBlob() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if appCfg.AdminState != adminEnable {
+				t.Errorf("admin-state = %q, want %q", appCfg.AdminState, adminEnable)
+			}
+			dest, ok := appCfg.Destination["d1"]
+			if !ok {
+				t.Fatalf("destination d1 not found: %+v", appCfg.Destination)
+			}
+			if d := dest.Destination; d.Address.Value != "172.20.20.2" || d.Port.Value != "57401" || !d.NoTLS.Value {
+				t.Errorf("destination d1 = %+v", d)
+			}
+			tun, ok := appCfg.Tunnel["t1"]
+			if !ok {
+				t.Fatalf("tunnel t1 not found: %+v", appCfg.Tunnel)
+			}
+			if tun.Tunnel.AdminState != adminEnable {
+				t.Errorf("tunnel t1 admin-state = %q, want %q", tun.Tunnel.AdminState, adminEnable)
+			}
+			if _, ok := tun.Tunnel.Destination["d1"]; !ok || len(tun.Tunnel.Destination) != 1 {
+				t.Errorf("tunnel t1 destinations = %+v, want d1", tun.Tunnel.Destination)
+			}
+			tg, ok := tun.Tunnel.Target["tg1"]
+			if !ok {
+				t.Fatalf("target tg1 not found: %+v", tun.Tunnel.Target)
+			}
+			if tg.Target.Type.SSHServer == nil || !tg.Target.Type.SSHServer.Value || tg.Target.LocalAddress.Value != "localhost:22" {
+				t.Errorf("target tg1 = %+v", tg.Target)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		blob     string
+		wantErrs int
+	}{
+		{
+			name: "valid",
+			blob: `{"destination":{"name":"d1","address":"172.20.20.2"},
+				"tunnel":{"name":"t1","destination":{"name":"d1"},"target":{"name":"tg1"}}}`,
+		},
+		{
+			name: "missing address and unknown destination",
+			blob: `{"destination":{"name":"d1"},
+				"tunnel":{"name":"t1","destination":[{"name":"d1"},{"name":"d2"}]}}`,
+			wantErrs: 2,
+		},
+		{
+			name:     "tunnel without destination",
+			blob:     `{"tunnel":{"name":"t1"}}`,
+			wantErrs: 1,
+		},
+		{
+			name: "custom type without local-address",
+			blob: `{"destination":{"name":"d1","address":"172.20.20.2"},
+				"tunnel":{"name":"t1","destination":{"name":"d1"},"target":{"name":"tg1","type":{"custom":["http"]}}}}`,
+			wantErrs: 1,
+		},
+		{
+			name: "invalid dampening thresholds",
+			blob: `{"destination":{"name":"d1","address":"172.20.20.2",
+				"dampening":{"admin-state":"enable","reuse-threshold":3000,"suppress-threshold":2000}}}`,
+			wantErrs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appCfg, err := parseGrpcTunnelBlob(testBlob(t, tt.blob))
+			if err != nil {
+				t.Fatalf("parseGrpcTunnelBlob() error = %v", err)
+			}
+			a := newTestApp()
+			a.config.app = appCfg
+			errs := a.validateConfig(io.Discard)
+			if len(errs) != tt.wantErrs {
+				t.Errorf("validateConfig() = %v, want %d error(s)", errs, tt.wantErrs)
+			}
+		})
+	}
+}
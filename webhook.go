@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	webhookPath = ".system.grpc_tunnel.webhook"
+
+	defaultWebhookTimeout = 5 * time.Second
+	defaultWebhookRetries = 3
+	webhookQueueSize      = 256
+	webhookRetryInterval  = time.Second
+	// the signature is "sha256=" followed by the hex encoded HMAC-SHA256 of the request body, keyed with the webhook secret
+	webhookSignatureHeader  = "X-Grpc-Tunnel-Signature"
+	webhookEventHeader      = "X-Grpc-Tunnel-Event"
+	webhookEventStateChange = "state-change"
+	// old-state of the first transition of an object
+	webhookOldStateCreated = "created"
+)
+
+type webhookCfg struct {
+	Webhook struct {
+		AdminState      string       `json:"admin_state,omitempty"`
+		URL             stringValue  `json:"url,omitempty"`
+		Secret          stringValue  `json:"secret,omitempty"`
+		Timeout         uint32Value  `json:"timeout,omitempty"`
+		Retries         *uint32Value `json:"retries,omitempty"`
+		NetworkInstance stringValue  `json:"network_instance,omitempty"`
+		SkipVerify      boolValue    `json:"skip_verify,omitempty"`
+	} `json:"webhook,omitempty"`
+}
+
+// stateObject identifies the object of a state transition,
+// the app itself if no field is set.
+type stateObject struct {
+	Tunnel      string
+	Destination string
+	Target      string
+}
+
+// stateEvent is the JSON body sent to the webhooks on each oper-state transition.
+type stateEvent struct {
+	Node        string `json:"node,omitempty"`
+	Timestamp   string `json:"timestamp,omitempty"`
+	Tunnel      string `json:"tunnel,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Target      string `json:"target,omitempty"`
+	OldState    string `json:"old-state,omitempty"`
+	NewState    string `json:"new-state,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// webhook delivers the state events to a configured URL.
+type webhook struct {
+	name    string
+	url     string
+	secret  []byte
+	retries int
+	// delay before the first retry, doubled on each retry
+	retryInterval time.Duration
+	client        *http.Client
+	events        chan []byte
+	cancel        context.CancelFunc
+
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+}
+
+type webhookStatistics struct {
+	SentEvents    uint64Value `json:"sent_events,omitempty"`
+	FailedEvents  uint64Value `json:"failed_events,omitempty"`
+	DroppedEvents uint64Value `json:"dropped_events,omitempty"`
+}
+
+// webhooks holds the running webhooks, by name.
+type webhooks struct {
+	m     *sync.Mutex
+	hooks map[string]*webhook
+}
+
+func newWebhooks() *webhooks {
+	return &webhooks{
+		m:     new(sync.Mutex),
+		hooks: make(map[string]*webhook),
+	}
+}
+
+func newWebhook(name string, cfg *webhookCfg) *webhook {
+	timeout := defaultWebhookTimeout
+	if cfg.Webhook.Timeout.Value > 0 {
+		timeout = time.Duration(cfg.Webhook.Timeout.Value) * time.Second
+	}
+	retries := defaultWebhookRetries
+	if cfg.Webhook.Retries != nil {
+		retries = int(cfg.Webhook.Retries.Value)
+	}
+	netIns := cfg.Webhook.NetworkInstance.Value
+	if netIns == "" {
+		netIns = "mgmt"
+	}
+	return &webhook{
+		name:          name,
+		url:           cfg.Webhook.URL.Value,
+		secret:        []byte(cfg.Webhook.Secret.Value),
+		retries:       retries,
+		retryInterval: webhookRetryInterval,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return dialNetworkInstance(ctx, netIns, network, addr, timeout)
+				},
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.Webhook.SkipVerify.Value},
+			},
+		},
+		events: make(chan []byte, webhookQueueSize),
+	}
+}
+
+// run sends the queued events until ctx is done.
+func (w *webhook) run(ctx context.Context, updateStats func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case body := <-w.events:
+			err := w.send(ctx, body)
+			if ctx.Err() != nil {
+				// webhook stopped
+				return
+			}
+			if err != nil {
+				log.WithField("webhook", w.name).Errorf("failed to send event: %v", err)
+				w.failed.Add(1)
+			} else {
+				w.sent.Add(1)
+			}
+			updateStats()
+		}
+	}
+}
+
+// send posts body to the webhook URL, retrying on failure.
+func (w *webhook) send(ctx context.Context, body []byte) error {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	var err error
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(w.retryInterval * time.Duration(1<<(attempt-1))):
+			}
+		}
+		err = w.post(ctx, body, signature)
+		if err == nil {
+			return nil
+		}
+		log.WithField("webhook", w.name).Debugf("attempt %d failed: %v", attempt+1, err)
+	}
+	return err
+}
+
+func (w *webhook) post(ctx context.Context, body []byte, signature string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, webhookEventStateChange)
+	if len(w.secret) > 0 {
+		req.Header.Set(webhookSignatureHeader, signature)
+	}
+	rsp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, rsp.Body)
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %s", rsp.Status)
+	}
+	return nil
+}
+
+// notifyWebhooks queues a state transition event to all the running webhooks,
+// the event is dropped for the webhooks with a full queue.
+// The first state of an object, recorded when it is created or when the app starts,
+// is sent with the old-state "created".
+func (a *app) notifyWebhooks(obj stateObject, st *stateTransition) {
+	a.webhooks.m.Lock()
+	defer a.webhooks.m.Unlock()
+	if len(a.webhooks.hooks) == 0 {
+		return
+	}
+	oldState := strings.TrimPrefix(st.OldState, "OPER_STATE_")
+	if oldState == "" {
+		oldState = webhookOldStateCreated
+	}
+	b, err := json.Marshal(&stateEvent{
+		Node:        a.config.sysInfo.Name,
+		Timestamp:   st.Timestamp.Value,
+		Tunnel:      obj.Tunnel,
+		Destination: obj.Destination,
+		Target:      obj.Target,
+		OldState:    oldState,
+		NewState:    strings.TrimPrefix(st.NewState, "OPER_STATE_"),
+		Reason:      st.Reason.Value,
+	})
+	if err != nil {
+		log.Errorf("failed to marshal state event: %v", err)
+		return
+	}
+	for _, w := range a.webhooks.hooks {
+		select {
+		case w.events <- b:
+		default:
+			w.dropped.Add(1)
+			log.WithField("webhook", w.name).Warn("queue full, event dropped")
+			a.updateWebhookStatisticsTelemetry(w)
+		}
+	}
+}
+
+// startWebhook starts the webhook name, replacing the running one if any.
+func (a *app) startWebhook(name string, cfg *webhookCfg) {
+	a.stopWebhook(name)
+	if cfg.Webhook.AdminState != adminEnable || cfg.Webhook.URL.Value == "" {
+		return
+	}
+	w := newWebhook(name, cfg)
+	ctx, cancel := context.WithCancel(a.ctx)
+	w.cancel = cancel
+	a.webhooks.m.Lock()
+	a.webhooks.hooks[name] = w
+	a.webhooks.m.Unlock()
+	go w.run(ctx, func() { a.updateWebhookStatisticsTelemetry(w) })
+	log.WithField("webhook", name).Infof("sending state events to %s", w.url)
+}
+
+// stopWebhook stops the webhook name, its queued events are dropped.
+func (a *app) stopWebhook(name string) {
+	a.webhooks.m.Lock()
+	defer a.webhooks.m.Unlock()
+	if w, ok := a.webhooks.hooks[name]; ok {
+		w.cancel()
+		delete(a.webhooks.hooks, name)
+	}
+}
+
+func (a *app) stopWebhooks() {
+	a.webhooks.m.Lock()
+	names := make([]string, 0, len(a.webhooks.hooks))
+	for name := range a.webhooks.hooks {
+		names = append(names, name)
+	}
+	a.webhooks.m.Unlock()
+	for _, name := range names {
+		a.stopWebhook(name)
+	}
+}
+
+// ".system.grpc_tunnel.webhook" handlers
+func (a *app) handleWebhook(ctx context.Context, txCfg *ndk.ConfigNotification) {
+	keys := txCfg.GetKey().GetKeys()
+	if len(keys) != 1 {
+		log.Errorf("unexpected number of keys in path %q: %v: %+v", webhookPath, keys, txCfg)
+		return
+	}
+	name := keys[0]
+	switch txCfg.GetOp() {
+	case ndk.SdkMgrOperation_Create, ndk.SdkMgrOperation_Update:
+		a.handleWebhookChange(ctx, name, txCfg.GetData())
+	case ndk.SdkMgrOperation_Delete:
+		a.handleWebhookDelete(ctx, name)
+	}
+}
+
+func (a *app) handleWebhookChange(ctx context.Context, name string, cfgData *ndk.ConfigData) {
+	newWebhook := new(webhookCfg)
+	err := json.Unmarshal([]byte(cfgData.GetJson()), newWebhook)
+	if err != nil {
+		log.Errorf("failed to unmarshal path %q config %+v", webhookPath, cfgData)
+		return
+	}
+	if a.config.app.Webhook == nil {
+		a.config.app.Webhook = make(map[string]*webhookCfg)
+	}
+	oldWebhook, ok := a.config.app.Webhook[name]
+	a.config.app.Webhook[name] = newWebhook
+	// restarting the webhook drops its queued events
+	if !ok || !reflect.DeepEqual(oldWebhook, newWebhook) {
+		a.startWebhook(name, newWebhook)
+	}
+	a.updateWebhookTelemetry(name, newWebhook)
+}
+
+func (a *app) handleWebhookDelete(ctx context.Context, name string) {
+	a.stopWebhook(name)
+	delete(a.config.app.Webhook, name)
+	jsPath := fmt.Sprintf("%s{.name==\"%s\"}", webhookPath, name)
+	log.Infof("Deleting telemetry path %s", jsPath)
+	a.deleteTelemetryPath(jsPath)
+}
+
+func (a *app) updateWebhookTelemetry(name string, cfg *webhookCfg) {
+	// do not expose the webhook secret in the state
+	wh := *cfg
+	wh.Webhook.Secret = stringValue{}
+	jsData, err := json.Marshal(wh)
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	p := fmt.Sprintf("%s{.name==\"%s\"}", webhookPath, name)
+	a.updateTelemetryPathConfig(p, string(jsData))
+}
+
+func (a *app) updateWebhookStatisticsTelemetry(w *webhook) {
+	jsData, err := json.Marshal(&webhookStatistics{
+		SentEvents:    uint64Value{Value: w.sent.Load()},
+		FailedEvents:  uint64Value{Value: w.failed.Load()},
+		DroppedEvents: uint64Value{Value: w.dropped.Load()},
+	})
+	if err != nil {
+		log.Errorf("failed to marshal json data: %v", err)
+		return
+	}
+	p := fmt.Sprintf("%s{.name==\"%s\"}.statistics", webhookPath, w.name)
+	a.updateTelemetryPathConfig(p, string(jsData))
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nokia/srlinux-ndk-go/ndk"
+)
+
+func TestHandleWebhookChange(t *testing.T) {
+	const (
+		enabled  = `{"webhook":{"admin_state":"ADMIN_STATE_enable","url":{"value":"https://collector:8443/events"}}}`
+		modified = `{"webhook":{"admin_state":"ADMIN_STATE_enable","url":{"value":"https://collector:8443/events"},"retries":{"value":1}}}`
+		disabled = `{"webhook":{"admin_state":"ADMIN_STATE_disable","url":{"value":"https://collector:8443/events"}}}`
+	)
+	type update struct {
+		cfg         string
+		wantRunning bool
+		// the running webhook is expected to be replaced
+		wantRestarted bool
+	}
+	tests := []struct {
+		name    string
+		updates []update
+	}{
+		{
+			name: "unchanged config",
+			updates: []update{
+				{cfg: enabled, wantRunning: true, wantRestarted: true},
+				{cfg: enabled, wantRunning: true},
+			},
+		},
+		{
+			name: "changed config",
+			updates: []update{
+				{cfg: enabled, wantRunning: true, wantRestarted: true},
+				{cfg: modified, wantRunning: true, wantRestarted: true},
+				{cfg: modified, wantRunning: true},
+			},
+		},
+		{
+			name: "disabled",
+			updates: []update{
+				{cfg: enabled, wantRunning: true, wantRestarted: true},
+				{cfg: disabled},
+				{cfg: disabled},
+				{cfg: enabled, wantRunning: true, wantRestarted: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp()
+			defer a.stopWebhooks()
+			for i, u := range tt.updates {
+				prev := a.webhooks.hooks["wh1"]
+				a.handleWebhookChange(context.Background(), "wh1", &ndk.ConfigData{DataType: &ndk.ConfigData_Json{Json: u.cfg}})
+				w, ok := a.webhooks.hooks["wh1"]
+				if ok != u.wantRunning {
+					t.Fatalf("update %d: running = %v, want %v", i+1, ok, u.wantRunning)
+				}
+				if got := w != prev; ok && got != u.wantRestarted {
+					t.Errorf("update %d: restarted = %v, want %v", i+1, got, u.wantRestarted)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyWebhooks(t *testing.T) {
+	a := newTestApp()
+	w := newWebhook("wh1", new(webhookCfg))
+	a.webhooks.hooks[w.name] = w
+	tests := []struct {
+		name string
+		obj  stateObject
+		st   *stateTransition
+		want stateEvent
+	}{
+		{
+			name: "created",
+			obj:  stateObject{Tunnel: "t1"},
+			st:   &stateTransition{Timestamp: stringValue{Value: "2026-10-19T10:00:00Z"}, NewState: operUp},
+			want: stateEvent{Node: "srl1", Timestamp: "2026-10-19T10:00:00Z", Tunnel: "t1", OldState: webhookOldStateCreated, NewState: "up"},
+		},
+		{
+			name: "transition",
+			obj:  stateObject{Tunnel: "t1", Destination: "d1", Target: "tg1"},
+			st:   &stateTransition{OldState: operUp, NewState: operDown, Reason: stringValue{Value: "connection lost"}},
+			want: stateEvent{Node: "srl1", Tunnel: "t1", Destination: "d1", Target: "tg1", OldState: "up", NewState: "down", Reason: "connection lost"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.notifyWebhooks(tt.obj, tt.st)
+			got := stateEvent{}
+			if err := json.Unmarshal(<-w.events, &got); err != nil {
+				t.Fatalf("failed to decode event: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("event = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	// the events are dropped once the queue is full
+	for i := 0; i < webhookQueueSize+2; i++ {
+		a.notifyWebhooks(stateObject{}, &stateTransition{OldState: operUp, NewState: operDown})
+	}
+	if len(w.events) != webhookQueueSize || w.dropped.Load() != 2 {
+		t.Errorf("queued %d events, dropped %d, want %d and 2", len(w.events), w.dropped.Load(), webhookQueueSize)
+	}
+}
+
+func TestWebhookRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		secret  string
+		// response status of each request, 200 once exhausted
+		statuses   []int
+		wantSent   uint64
+		wantFailed uint64
+		// expected number of requests
+		wantRequests int32
+	}{
+		{
+			name:         "sent",
+			retries:      2,
+			secret:       "s3cr3t",
+			wantSent:     1,
+			wantRequests: 1,
+		},
+		{
+			name:         "sent after retries",
+			retries:      2,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusInternalServerError},
+			wantSent:     1,
+			wantRequests: 3,
+		},
+		{
+			name:         "failed after retries",
+			retries:      1,
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusBadRequest},
+			wantFailed:   1,
+			wantRequests: 2,
+		},
+		{
+			name:         "no retry",
+			retries:      0,
+			statuses:     []int{http.StatusNotFound},
+			wantFailed:   1,
+			wantRequests: 1,
+		},
+	}
+	body := []byte(`{"node":"srl1","new-state":"up"}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1))
+				b, _ := io.ReadAll(r.Body)
+				if string(b) != string(body) || r.Header.Get(webhookEventHeader) != webhookEventStateChange {
+					t.Errorf("request %d: body %s, event %q", n, b, r.Header.Get(webhookEventHeader))
+				}
+				if tt.secret != "" {
+					mac := hmac.New(sha256.New, []byte(tt.secret))
+					mac.Write(body)
+					if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get(webhookSignatureHeader) != want {
+						t.Errorf("request %d: signature %q, want %q", n, r.Header.Get(webhookSignatureHeader), want)
+					}
+				}
+				if n <= len(tt.statuses) {
+					rw.WriteHeader(tt.statuses[n-1])
+				}
+			}))
+			defer srv.Close()
+			cfg := new(webhookCfg)
+			cfg.Webhook.URL.Value = srv.URL
+			cfg.Webhook.Secret.Value = tt.secret
+			cfg.Webhook.Retries = &uint32Value{Value: uint32(tt.retries)}
+			w := newWebhook("wh1", cfg)
+			w.client = srv.Client()
+			w.retryInterval = time.Millisecond
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			updated := make(chan struct{}, 1)
+			wg := new(sync.WaitGroup)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w.run(ctx, func() { updated <- struct{}{} })
+			}()
+			w.events <- body
+			select {
+			case <-updated:
+			case <-time.After(5 * time.Second):
+				t.Fatal("event not processed")
+			}
+			cancel()
+			wg.Wait()
+			if w.sent.Load() != tt.wantSent || w.failed.Load() != tt.wantFailed {
+				t.Errorf("sent %d, failed %d, want %d, %d", w.sent.Load(), w.failed.Load(), tt.wantSent, tt.wantFailed)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("%d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}